@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"reflect"
 	"sync"
 	"syscall"
 	"time"
@@ -20,9 +21,9 @@ import (
 
 var (
 	configPath = flag.String("config", "config/config.yaml", "path to the config file")
-	// logFormat is currently unused but kept for future use
-	_       = flag.String("log-format", "json", "log format (json or console)")
-	version = flag.Bool("version", false, "print version information and exit")
+	logFormat  = flag.String("log-format", "json", "log format (json or console), overrides global.log.format")
+	version    = flag.Bool("version", false, "print version information and exit")
+	watch      = flag.Bool("watch", false, "reload the configuration when the config file changes")
 
 	buildVersion = "dev"
 	buildDate    = "unknown"
@@ -57,7 +58,12 @@ func main() {
 
 	cfg := config.GetConfig()
 
-	appLogger = logger.NewLogger(*cfg)
+	var err error
+	appLogger, err = logger.NewLogger(loggerConfig(*cfg))
+	if err != nil {
+		fmt.Printf("Error creating logger: %v\n", err)
+		os.Exit(1)
+	}
 	utils.Logger = appLogger
 
 	defer func() {
@@ -76,8 +82,10 @@ func main() {
 
 	startTime := time.Now()
 
-	go controllers.DefaultControllerServe(ctx, wg)
-	go controllers.InitListenerControllers(ctx, wg)
+	controllers.DefaultControllerServe(ctx, wg)
+	controllers.InitListenerControllers(ctx, wg)
+
+	setupReload(ctx, wg)
 
 	appLogger.Info("Reproxy started successfully",
 		"startup_time_ms", time.Since(startTime).Milliseconds())
@@ -94,6 +102,28 @@ func main() {
 	stop()
 }
 
+// loggerConfig applies the --log-format flag, when given, over global.log.format.
+func loggerConfig(cfg config.Config) config.Config {
+	formatSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "log-format" {
+			formatSet = true
+		}
+	})
+	if !formatSet {
+		return cfg
+	}
+
+	logConfig := config.LogConfig{}
+	if cfg.Global.Log != nil {
+		logConfig = *cfg.Global.Log
+	}
+	logConfig.Format = *logFormat
+	cfg.Global.Log = &logConfig
+
+	return cfg
+}
+
 func loadConfig() error {
 	defer func() {
 		if r := recover(); r != nil {
@@ -106,6 +136,69 @@ func loadConfig() error {
 	return nil
 }
 
+// setupReload re-applies the configuration file on SIGHUP and, with --watch, whenever
+// the file changes. An invalid configuration is logged and the current one is kept.
+func setupReload(ctx context.Context, wg *sync.WaitGroup) {
+	reload := func() {
+		appLogger.Info("Reloading configuration", "config_path", *configPath)
+
+		newCfg, err := config.ParseConfig(*configPath)
+		if err != nil {
+			appLogger.Error("Configuration reload failed, keeping the current configuration", "error", err)
+			return
+		}
+
+		if newCfg.Global.Port != config.GetConfig().Global.Port {
+			appLogger.Warn("Changing global.port requires a restart",
+				"current_port", config.GetConfig().Global.Port,
+				"new_port", newCfg.Global.Port)
+		}
+
+		if !reflect.DeepEqual(newCfg.Global.Log, config.GetConfig().Global.Log) {
+			appLogger.Warn("Changing global.log requires a restart")
+		}
+
+		oldLogLevel := config.GetConfig().Global.LogLevel
+
+		if err := controllers.ApplyConfig(ctx, wg, newCfg); err != nil {
+			appLogger.Error("Configuration reload failed, keeping the current configuration", "error", err)
+			return
+		}
+
+		if newCfg.Global.LogLevel != oldLogLevel {
+			if err := logger.SetLevel(newCfg.Global.LogLevel); err != nil {
+				appLogger.Error("Failed to change the log level", "error", err)
+			}
+		}
+
+		appLogger.Info("Configuration reloaded")
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				reload()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if *watch {
+		err := config.WatchConfig(ctx, *configPath, reload, func(err error) {
+			appLogger.Error("Error watching configuration file", "error", err)
+		})
+		if err != nil {
+			appLogger.Error("Failed to watch configuration file", "error", err)
+		}
+	}
+}
+
 func setupSignalHandling() (context.Context, context.CancelFunc) {
 	return signal.NotifyContext(
 		context.Background(),
@@ -6,8 +6,12 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
 )
 
@@ -17,28 +21,147 @@ type Config struct {
 }
 
 type GlobalConfig struct {
-	Port     int    `mapstructure:"port" validate:"required,gt=0,lt=65536"`
-	LogLevel string `mapstructure:"log_level" validate:"required,oneof=debug info warn error fatal"`
+	Port     int          `mapstructure:"port" validate:"required,gt=0,lt=65536"`
+	LogLevel string       `mapstructure:"log_level" validate:"required,oneof=debug info warn error fatal"`
+	Log      *LogConfig   `mapstructure:"log" validate:"omitempty"`
+	ACME     *ACMEConfig  `mapstructure:"acme" validate:"omitempty"`
+	Admin    *AdminConfig `mapstructure:"admin" validate:"omitempty"`
+
+	RetryBudget *RetryBudgetConfig `mapstructure:"retry_budget" validate:"omitempty"`
+
+	// TrustedProxies are the peers whose client_ip_headers are believed, and the only
+	// ones allowed to send a PROXY protocol header when set.
+	TrustedProxies  []string `mapstructure:"trusted_proxies" validate:"omitempty,dive,cidr"`
+	ClientIPHeaders []string `mapstructure:"client_ip_headers" default:"X-Forwarded-For,Forwarded,X-Real-IP" validate:"omitempty,dive,required"`
+}
+
+// RetryBudgetConfig caps the retries of all handlers to a percentage of the requests
+// of the last 10 seconds, allowing at least min_per_second retries per second.
+type RetryBudgetConfig struct {
+	Percent      int `mapstructure:"percent" default:"20" validate:"omitempty,gt=0,lte=100"`
+	MinPerSecond int `mapstructure:"min_per_second" default:"10" validate:"omitempty,gt=0"`
+}
+
+type LogConfig struct {
+	Format   string             `mapstructure:"format" default:"json" validate:"omitempty,oneof=json console"`
+	Outputs  []LogOutputConfig  `mapstructure:"outputs" validate:"omitempty,dive"`
+	Sampling *LogSamplingConfig `mapstructure:"sampling" validate:"omitempty"`
+}
+
+type LogOutputConfig struct {
+	Path     string         `mapstructure:"path" validate:"required"`
+	Rotation RotationConfig `mapstructure:",squash"`
+}
+
+// LogSamplingConfig limits debug logs to Initial entries with the same message per
+// second, then keeps one out of every Thereafter entries.
+type LogSamplingConfig struct {
+	Initial    int `mapstructure:"initial" default:"100" validate:"omitempty,gt=0"`
+	Thereafter int `mapstructure:"thereafter" default:"100" validate:"omitempty,gt=0"`
+}
+
+type AdminConfig struct {
+	Address      string          `mapstructure:"address" validate:"omitempty,hostname_port"`
+	UnixSocket   string          `mapstructure:"unix_socket" validate:"omitempty"`
+	Tokens       []string        `mapstructure:"tokens" validate:"omitempty,dive,min=16" redact:"true"`
+	AllowedCIDRs []string        `mapstructure:"allowed_cidrs" validate:"omitempty,dive,cidr"`
+	TLS          *AdminTLSConfig `mapstructure:"tls" validate:"omitempty"`
+
+	Timeouts *ServerTimeoutsConfig `mapstructure:"timeouts" validate:"omitempty"`
+}
+
+type AdminTLSConfig struct {
+	CertFile string `mapstructure:"cert_file" validate:"required,file"`
+	KeyFile  string `mapstructure:"key_file" validate:"required,file"`
+	ClientCA string `mapstructure:"client_ca" validate:"omitempty,file"`
+}
+
+type ACMEConfig struct {
+	Email        string `mapstructure:"email" validate:"omitempty,email"`
+	DirectoryURL string `mapstructure:"directory_url" default:"https://acme-v02.api.letsencrypt.org/directory" validate:"omitempty,url"`
+	Storage      string `mapstructure:"storage" default:"certificates" validate:"omitempty"`
+	CARoot       string `mapstructure:"ca_root" validate:"omitempty,file"`
+	HTTPPort     int    `mapstructure:"http_port" default:"80" validate:"omitempty,gt=0,lt=65536"`
+	RenewBefore  int    `mapstructure:"renew_before" default:"30" validate:"omitempty,gt=0"`
 }
 
 type ListenerConfig struct {
-	Host     []string        `mapstructure:"host" validate:"required,dive,hostname_port"`
-	Handlers []HandlerConfig `mapstructure:"handlers" validate:"required,dive"`
+	Host      []string         `mapstructure:"host" validate:"required,dive,required"`
+	TLS       *TLSConfig       `mapstructure:"tls" validate:"omitempty"`
+	AccessLog *AccessLogConfig `mapstructure:"access_log" validate:"omitempty"`
+	Handlers  []HandlerConfig  `mapstructure:"handlers" validate:"required,dive"`
+
+	Timeouts      *ServerTimeoutsConfig `mapstructure:"timeouts" validate:"omitempty"`
+	ProxyProtocol bool                  `mapstructure:"proxy_protocol"`
+}
+
+// ServerTimeoutsConfig bounds the time spent on client connections, in seconds. read
+// and write are disabled unless set.
+type ServerTimeoutsConfig struct {
+	ReadHeader int `mapstructure:"read_header" default:"10" validate:"omitempty,gt=0"`
+	Read       int `mapstructure:"read" validate:"omitempty,gt=0"`
+	Write      int `mapstructure:"write" validate:"omitempty,gt=0"`
+	Idle       int `mapstructure:"idle" default:"120" validate:"omitempty,gt=0"`
+}
+
+type AccessLogConfig struct {
+	Disabled bool           `mapstructure:"disabled"`
+	Format   string         `mapstructure:"format" default:"combined" validate:"omitempty,oneof=common combined json"`
+	Output   string         `mapstructure:"output" default:"stdout" validate:"omitempty"`
+	Fields   []string       `mapstructure:"fields" validate:"omitempty,dive,startswith={,endswith=}"`
+	Rotation RotationConfig `mapstructure:",squash"`
+}
+
+// RotationConfig controls size-based rotation of log files.
+type RotationConfig struct {
+	MaxSize    int  `mapstructure:"max_size" default:"100" validate:"omitempty,gt=0"`
+	MaxBackups int  `mapstructure:"max_backups" validate:"omitempty,gte=0"`
+	MaxAge     int  `mapstructure:"max_age" validate:"omitempty,gte=0"`
+	Compress   bool `mapstructure:"compress"`
+}
+
+type TLSConfig struct {
+	ACME         bool                `mapstructure:"acme"`
+	Certificates []CertificateConfig `mapstructure:"certificates" validate:"required_without=ACME,dive"`
+	MinVersion   string              `mapstructure:"min_version" default:"1.2" validate:"omitempty,oneof=1.0 1.1 1.2 1.3"`
+	CipherSuites []string            `mapstructure:"cipher_suites" validate:"omitempty,dive"`
+}
+
+type CertificateConfig struct {
+	Hosts    []string `mapstructure:"hosts" validate:"omitempty"`
+	CertFile string   `mapstructure:"cert_file" validate:"required,file"`
+	KeyFile  string   `mapstructure:"key_file" validate:"required,file"`
 }
 
 type HandlerConfig struct {
+	Name           string               `mapstructure:"name" validate:"omitempty"`
+	Priority       int                  `mapstructure:"priority"`
+	AccessLog      *AccessLogConfig     `mapstructure:"access_log" validate:"omitempty"`
 	Matchers       MatchersConfig       `mapstructure:"matchers" validate:"omitempty"`
 	StaticResponse StaticResponseConfig `mapstructure:"static_response"`
 	StaticFiles    StaticFilesConfig    `mapstructure:"static_files"`
 	ReverseProxy   ReverseProxyConfig   `mapstructure:"reverse_proxy"`
 }
 
+// MatchersConfig lists the conditions a request must all meet. not, any_of and all_of
+// nest further blocks to negate, OR and AND them.
 type MatchersConfig struct {
-	Headers     map[string]string `mapstructure:"headers" validate:"omitempty,dive"`
-	Query       map[string]string `mapstructure:"query" validate:"omitempty,dive"`
-	Path        string            `mapstructure:"path" validate:"omitempty"`
-	Method      []string          `mapstructure:"method" validate:"omitempty,dive,oneof=GET POST PUT DELETE PATCH OPTIONS HEAD *"`
-	ClientCIDRs []string          `mapstructure:"client_cidrs" validate:"omitempty,dive,cidr"`
+	Headers       map[string]string `mapstructure:"headers" validate:"omitempty,dive" redact:"true"`
+	HeaderRegexp  map[string]string `mapstructure:"header_regexp" validate:"omitempty,dive"`
+	HeaderPresent []string          `mapstructure:"header_present" validate:"omitempty,dive,required"`
+	HeaderAbsent  []string          `mapstructure:"header_absent" validate:"omitempty,dive,required"`
+	Query         map[string]string `mapstructure:"query" validate:"omitempty,dive"`
+	QueryRegexp   map[string]string `mapstructure:"query_regexp" validate:"omitempty,dive"`
+	Path          string            `mapstructure:"path" validate:"omitempty"`
+	PathMode      string            `mapstructure:"path_mode" default:"prefix" validate:"omitempty,oneof=exact prefix glob regex"`
+	Method        []string          `mapstructure:"method" validate:"omitempty,dive,oneof=GET POST PUT DELETE PATCH OPTIONS HEAD *"`
+	ClientCIDRs   []string          `mapstructure:"client_cidrs" validate:"omitempty,dive,cidr"`
+	Protocol      []string          `mapstructure:"protocol" validate:"omitempty,dive,oneof=http https http1 http2"`
+	SNI           []string          `mapstructure:"sni" validate:"omitempty,dive,required"`
+
+	Not   *MatchersConfig  `mapstructure:"not" validate:"omitempty"`
+	AnyOf []MatchersConfig `mapstructure:"any_of" validate:"omitempty,dive"`
+	AllOf []MatchersConfig `mapstructure:"all_of" validate:"omitempty,dive"`
 }
 
 type StaticResponseConfig struct {
@@ -54,31 +177,194 @@ type ReverseProxyConfig struct {
 	Rewrite       string              `mapstructure:"rewrite" validate:"omitempty"`
 	Upstreams     UpstreamConfig      `mapstructure:"upstreams" validate:"omitempty,required"`
 	LoadBalancing LoadBalancingConfig `mapstructure:"load_balancing" validate:"omitempty"`
-	AddHeaders    map[string]string   `mapstructure:"add_headers" validate:"omitempty,dive"`
+	AddHeaders    map[string]string   `mapstructure:"add_headers" validate:"omitempty,dive" redact:"true"`
 	RemoveHeaders []string            `mapstructure:"remove_headers" validate:"omitempty,dive"`
+
+	TunnelIdleTimeout *int                    `mapstructure:"tunnel_idle_timeout" default:"300" validate:"omitempty,gte=0"`
+	HealthCheck       *HealthCheckConfig      `mapstructure:"health_check" validate:"omitempty"`
+	PassiveHealth     *PassiveHealthConfig    `mapstructure:"passive_health" validate:"omitempty"`
+	CircuitBreaker    *CircuitBreakerConfig   `mapstructure:"circuit_breaker" validate:"omitempty"`
+	Retry             *RetryConfig            `mapstructure:"retry" validate:"omitempty"`
+	Timeouts          *UpstreamTimeoutsConfig `mapstructure:"timeouts" validate:"omitempty"`
+	Transport         *TransportConfig        `mapstructure:"transport" validate:"omitempty"`
+	TLS               *UpstreamTLSConfig      `mapstructure:"tls" validate:"omitempty"`
+	Forwarding        *ForwardingConfig       `mapstructure:"forwarding" validate:"omitempty"`
+	ResponseHeaders   *ResponseHeadersConfig  `mapstructure:"response_headers" validate:"omitempty"`
+	ResponseRewrite   *ResponseRewriteConfig  `mapstructure:"response_rewrite" validate:"omitempty"`
+}
+
+// ResponseHeadersConfig edits the headers of upstream responses: remove, then set,
+// then add. Values may contain placeholders.
+type ResponseHeadersConfig struct {
+	Add    map[string]string `mapstructure:"add" validate:"omitempty,dive" redact:"true"`
+	Set    map[string]string `mapstructure:"set" validate:"omitempty,dive" redact:"true"`
+	Remove []string          `mapstructure:"remove" validate:"omitempty,dive,required"`
+}
+
+// ResponseRewriteConfig adapts the responses of backends unaware of the path prefix
+// they are served under.
+type ResponseRewriteConfig struct {
+	Location     bool                `mapstructure:"location"`
+	CookiePath   bool                `mapstructure:"cookie_path"`
+	CookieDomain bool                `mapstructure:"cookie_domain"`
+	Body         []BodyReplaceConfig `mapstructure:"body" validate:"omitempty,dive"`
+	ContentTypes []string            `mapstructure:"content_types" validate:"omitempty,dive,required"`
+	MaxBodySize  int64               `mapstructure:"max_body_size" default:"1048576" validate:"omitempty,gt=0"`
+}
+
+type BodyReplaceConfig struct {
+	Search  string `mapstructure:"search" validate:"required"`
+	Replace string `mapstructure:"replace"`
+}
+
+// ForwardingConfig controls the X-Forwarded-*, Forwarded and Via headers describing
+// the original request to upstreams. auto appends to the values sent by trusted
+// proxies and replaces the values sent by anyone else.
+type ForwardingConfig struct {
+	Mode       string `mapstructure:"mode" default:"auto" validate:"omitempty,oneof=auto append replace strip"`
+	Forwarded  bool   `mapstructure:"forwarded"`
+	DisableVia bool   `mapstructure:"disable_via"`
+}
+
+// UpstreamTLSConfig configures the TLS connections to https upstreams and their health
+// checks. ca replaces the system roots, cert_file and key_file enable mTLS.
+type UpstreamTLSConfig struct {
+	CA                 string `mapstructure:"ca" validate:"omitempty,file"`
+	CertFile           string `mapstructure:"cert_file" validate:"required_with=KeyFile,omitempty,file"`
+	KeyFile            string `mapstructure:"key_file" validate:"required_with=CertFile,omitempty,file"`
+	ServerName         string `mapstructure:"server_name" validate:"omitempty"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+}
+
+// TransportConfig tunes the connection pool to the upstreams. http2 is auto to
+// negotiate HTTP/2 with TLS upstreams, off for HTTP/1.1 only, or h2c for HTTP/2 without
+// TLS. flush_interval is in milliseconds, -1 flushes every write to the client.
+type TransportConfig struct {
+	MaxIdleConns        int    `mapstructure:"max_idle_conns" default:"100" validate:"omitempty,gt=0"`
+	MaxIdleConnsPerHost int    `mapstructure:"max_idle_conns_per_host" default:"32" validate:"omitempty,gt=0"`
+	MaxConnsPerHost     int    `mapstructure:"max_conns_per_host" validate:"omitempty,gt=0"`
+	KeepAlive           int    `mapstructure:"keep_alive" default:"30" validate:"omitempty,gt=0"`
+	HTTP2               string `mapstructure:"http2" default:"auto" validate:"omitempty,oneof=auto off h2c"`
+	FlushInterval       int    `mapstructure:"flush_interval" validate:"omitempty,gte=-1"`
+	PerBackend          bool   `mapstructure:"per_backend"`
+}
+
+// UpstreamTimeoutsConfig bounds the time spent on upstream connections, in seconds.
+// response_header and request, the deadline of a whole request including retries, are
+// disabled unless set. request does not apply to upgraded connections.
+type UpstreamTimeoutsConfig struct {
+	Dial           int `mapstructure:"dial" default:"10" validate:"omitempty,gt=0"`
+	TLSHandshake   int `mapstructure:"tls_handshake" default:"10" validate:"omitempty,gt=0"`
+	ResponseHeader int `mapstructure:"response_header" validate:"omitempty,gt=0"`
+	Request        int `mapstructure:"request" validate:"omitempty,gt=0"`
+	IdleConn       int `mapstructure:"idle_conn" default:"90" validate:"omitempty,gt=0"`
+}
+
+// RetryConfig configures which failed requests are retried, up to load_balancing.retries
+// times. Only idempotent requests whose body fits in max_body_size are retried. retry_on
+// lists connect_error, status codes such as 503, and status classes such as 5xx.
+type RetryConfig struct {
+	RetryOn     []string `mapstructure:"retry_on" validate:"omitempty,dive"`
+	BaseBackoff int      `mapstructure:"base_backoff" default:"25" validate:"omitempty,gt=0"`
+	MaxBackoff  int      `mapstructure:"max_backoff" default:"1000" validate:"omitempty,gt=0"`
+	MaxBodySize int64    `mapstructure:"max_body_size" default:"65536" validate:"omitempty,gte=0"`
+}
+
+// CircuitBreakerConfig configures the circuit breaker of every backend. Connection
+// failures and 5xx responses count as failures. An open breaker rejects requests for
+// cool_down seconds, then lets a number of probe requests through and closes once all
+// of them succeeded.
+type CircuitBreakerConfig struct {
+	Window              int `mapstructure:"window" default:"10" validate:"omitempty,gt=0"`
+	FailureRate         int `mapstructure:"failure_rate" default:"50" validate:"omitempty,gt=0,lte=100"`
+	MinRequests         int `mapstructure:"min_requests" default:"10" validate:"omitempty,gt=0"`
+	ConsecutiveFailures int `mapstructure:"consecutive_failures" default:"5" validate:"omitempty,gt=0"`
+	CoolDown            int `mapstructure:"cool_down" default:"30" validate:"omitempty,gt=0"`
+	Probes              int `mapstructure:"probes" default:"1" validate:"omitempty,gt=0"`
+}
+
+// PassiveHealthConfig configures outlier detection from live traffic. Ejected backends
+// are readmitted after base_ejection_time, doubled on every consecutive ejection.
+type PassiveHealthConfig struct {
+	Consecutive5xx      int     `mapstructure:"consecutive_5xx" default:"5" validate:"omitempty,gt=0"`
+	ConsecutiveFailures int     `mapstructure:"consecutive_failures" default:"3" validate:"omitempty,gt=0"`
+	LatencyFactor       float64 `mapstructure:"latency_factor" validate:"omitempty,gt=1"`
+	MinRequests         int     `mapstructure:"min_requests" default:"20" validate:"omitempty,gt=0"`
+	BaseEjectionTime    int     `mapstructure:"base_ejection_time" default:"30" validate:"omitempty,gt=0"`
+	MaxEjectionTime     int     `mapstructure:"max_ejection_time" default:"300" validate:"omitempty,gt=0"`
+	MaxEjectionPercent  int     `mapstructure:"max_ejection_percent" default:"50" validate:"omitempty,gt=0,lte=100"`
+}
+
+// HealthCheckConfig configures active health checks. Without a path backends are
+// checked by opening a TCP connection.
+type HealthCheckConfig struct {
+	Method         string            `mapstructure:"method" default:"GET" validate:"omitempty,oneof=GET HEAD POST OPTIONS"`
+	Path           string            `mapstructure:"path" validate:"omitempty,startswith=/"`
+	Port           int               `mapstructure:"port" validate:"omitempty,gt=0,lt=65536"`
+	Headers        map[string]string `mapstructure:"headers" validate:"omitempty,dive" redact:"true"`
+	ExpectedStatus []string          `mapstructure:"expected_status" default:"200-399" validate:"omitempty,dive"`
+	BodyRegex      string            `mapstructure:"body_regex" validate:"omitempty"`
+	Interval       int               `mapstructure:"interval" default:"20" validate:"omitempty,gt=0"`
+	Timeout        int               `mapstructure:"timeout" default:"10" validate:"omitempty,gt=0"`
+	Rise           int               `mapstructure:"rise" default:"1" validate:"omitempty,gt=0"`
+	Fall           int               `mapstructure:"fall" default:"1" validate:"omitempty,gt=0"`
 }
 
 type UpstreamConfig struct {
-	Static  []string                `mapstructure:"static" validate:"omitempty"`
+	Static  []StaticUpstreamConfig  `mapstructure:"static" validate:"omitempty,dive"`
 	Dynamic []DynamicUpstreamConfig `mapstructure:"dynamic" validate:"omitempty,dive"`
 }
 
+// StaticUpstreamConfig is written either as a plain URL or as an object with a weight.
+type StaticUpstreamConfig struct {
+	URL    string `mapstructure:"url" validate:"required,url"`
+	Weight int    `mapstructure:"weight" default:"1" validate:"omitempty,gt=0"`
+}
+
 type DynamicUpstreamConfig struct {
 	Type  string `mapstructure:"type" validate:"required,oneof=A AAAA CNAME"`
 	Value string `mapstructure:"value" validate:"required"`
 }
 
 type LoadBalancingConfig struct {
-	Strategy    string `mapstructure:"strategy" validate:"omitempty,oneof=round_robin random ip_hash uri_hash sticky"`
-	Retries     int    `mapstructure:"retries" default:"3" validate:"omitempty,gte=0,lte=10"`
-	TryInterval int    `mapstructure:"try_interval" default:"5" validate:"omitempty,gte=0,lte=60"`
+	Strategy string `mapstructure:"strategy" validate:"omitempty,oneof=round_robin weighted_round_robin least_conn weighted_least_conn random ip_hash uri_hash consistent_hash sticky"`
+	HashKey  string `mapstructure:"hash_key" validate:"omitempty"`
+	Retries  *int   `mapstructure:"retries" default:"3" validate:"omitempty,gte=0,lte=10"`
 }
 
 var (
-	cfg *Config
+	cfg atomic.Pointer[Config]
 )
 
+// ValidationError lists every validation failure of a configuration file.
+type ValidationError struct {
+	Messages []string
+}
+
+func (e *ValidationError) Error() string {
+	return "config validation failed: " + strings.Join(e.Messages, "; ")
+}
+
 func LoadConfig(path string) {
+	newCfg, err := ParseConfig(path)
+	if err != nil {
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			fmt.Println("Configuration validation errors:")
+			for _, message := range validationErr.Messages {
+				fmt.Printf("  - %s\n", message)
+			}
+			log.Fatalf("config validation failed, see errors above")
+		}
+		panic(err)
+	}
+
+	SetConfig(newCfg)
+}
+
+// ParseConfig reads and validates the configuration file without installing it,
+// so that a reload can be rejected while the current configuration keeps serving.
+func ParseConfig(path string) (*Config, error) {
 	v := viper.New()
 	v.SetConfigType("yaml")
 	v.SetConfigFile(path)
@@ -88,7 +374,7 @@ func LoadConfig(path string) {
 
 	err := v.ReadInConfig()
 	if err != nil {
-		panic(fmt.Errorf("fatal error config file: %w", err))
+		return nil, fmt.Errorf("fatal error config file: %w", err)
 	}
 
 	for _, k := range v.AllKeys() {
@@ -97,45 +383,85 @@ func LoadConfig(path string) {
 		}
 	}
 
-	if err = v.Unmarshal(&cfg); err != nil {
-		panic(fmt.Errorf("fatal error config file: %w", err))
+	var newCfg *Config
+	decodeHook := viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		staticUpstreamHook,
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	))
+	if err = v.Unmarshal(&newCfg, decodeHook); err != nil {
+		return nil, fmt.Errorf("fatal error config file: %w", err)
 	}
 
 	validate := validator.New(validator.WithRequiredStructEnabled())
 
-	err = validate.Struct(cfg)
+	err = validate.Struct(newCfg)
 	if err != nil {
 		var validateErrs validator.ValidationErrors
-		if errors.As(err, &validateErrs) {
-			fmt.Println("Configuration validation errors:")
-			for _, e := range validateErrs {
-				switch e.Tag() {
-				case "required":
-					fmt.Printf("  - %s is required but was not provided\n", e.Namespace())
-				case "gt", "gte":
-					fmt.Printf("  - %s must be greater than %s (got: %v)\n", e.Namespace(), e.Param(), e.Value())
-				case "lt", "lte":
-					fmt.Printf("  - %s must be less than %s (got: %v)\n", e.Namespace(), e.Param(), e.Value())
-				case "oneof":
-					fmt.Printf("  - %s must be one of [%s] (got: %v)\n", e.Namespace(), e.Param(), e.Value())
-				case "url":
-					fmt.Printf("  - %s must be a valid URL (got: %v)\n", e.Namespace(), e.Value())
-				case "dir":
-					fmt.Printf("  - %s must be a valid directory path (got: %v)\n", e.Namespace(), e.Value())
-				case "hostname_port":
-					fmt.Printf("  - %s must be a valid host:port combination (got: %v)\n", e.Namespace(), e.Value())
-				default:
-					fmt.Printf("  - %s failed validation: %s=%s (got: %v)\n", e.Namespace(), e.Tag(), e.Param(), e.Value())
-				}
-			}
+		if !errors.As(err, &validateErrs) {
+			return nil, err
+		}
+
+		messages := make([]string, 0, len(validateErrs))
+		for _, e := range validateErrs {
+			messages = append(messages, formatValidationError(e))
 		}
-		log.Fatalf("config validation failed, see errors above")
+		return nil, &ValidationError{Messages: messages}
 	}
+
+	return newCfg, nil
+}
+
+// staticUpstreamHook accepts plain URLs in upstreams.static, which is shorthand for an
+// upstream of weight 1.
+func staticUpstreamHook(from reflect.Type, to reflect.Type, data any) (any, error) {
+	if from.Kind() != reflect.String || to != reflect.TypeOf(StaticUpstreamConfig{}) {
+		return data, nil
+	}
+	return StaticUpstreamConfig{URL: data.(string)}, nil
+}
+
+func formatValidationError(e validator.FieldError) string {
+	switch e.Tag() {
+	case "required":
+		return fmt.Sprintf("%s is required but was not provided", e.Namespace())
+	case "required_without":
+		return fmt.Sprintf("%s is required when %s is not set", e.Namespace(), e.Param())
+	case "required_with":
+		return fmt.Sprintf("%s is required when %s is set", e.Namespace(), e.Param())
+	case "gt", "gte":
+		return fmt.Sprintf("%s must be greater than %s (got: %v)", e.Namespace(), e.Param(), e.Value())
+	case "lt", "lte":
+		return fmt.Sprintf("%s must be less than %s (got: %v)", e.Namespace(), e.Param(), e.Value())
+	case "oneof":
+		return fmt.Sprintf("%s must be one of [%s] (got: %v)", e.Namespace(), e.Param(), e.Value())
+	case "url":
+		return fmt.Sprintf("%s must be a valid URL (got: %v)", e.Namespace(), e.Value())
+	case "dir":
+		return fmt.Sprintf("%s must be a valid directory path (got: %v)", e.Namespace(), e.Value())
+	case "file":
+		return fmt.Sprintf("%s must be a valid file path (got: %v)", e.Namespace(), e.Value())
+	case "min":
+		return fmt.Sprintf("%s is below the minimum length or value of %s", e.Namespace(), e.Param())
+	case "cidr":
+		return fmt.Sprintf("%s must be a valid CIDR range (got: %v)", e.Namespace(), e.Value())
+	case "startswith", "endswith":
+		return fmt.Sprintf("%s must %s with %s (got: %v)", e.Namespace(), strings.TrimSuffix(e.Tag(), "with"), e.Param(), e.Value())
+	case "hostname_port":
+		return fmt.Sprintf("%s must be a valid host:port combination (got: %v)", e.Namespace(), e.Value())
+	default:
+		return fmt.Sprintf("%s failed validation: %s=%s (got: %v)", e.Namespace(), e.Tag(), e.Param(), e.Value())
+	}
+}
+
+func SetConfig(newCfg *Config) {
+	cfg.Store(newCfg)
 }
 
 func GetConfig() *Config {
-	if cfg == nil {
+	current := cfg.Load()
+	if current == nil {
 		panic("config is not loaded")
 	}
-	return cfg
+	return current
 }
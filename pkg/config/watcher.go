@@ -0,0 +1,63 @@
+package config
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const watchDebounce = 500 * time.Millisecond
+
+// WatchConfig calls onChange whenever the configuration file is written or replaced.
+// The parent directory is watched so that editors replacing the file through a rename
+// are noticed, and bursts of events are coalesced into a single call.
+func WatchConfig(ctx context.Context, path string, onChange func(), onError func(error)) error {
+	configPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	if err := watcher.Add(filepath.Dir(configPath)); err != nil {
+		_ = watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer func() {
+			_ = watcher.Close()
+		}()
+
+		var debounce <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != configPath || !event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+					continue
+				}
+				debounce = time.After(watchDebounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				onError(err)
+			case <-debounce:
+				debounce = nil
+				onChange()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
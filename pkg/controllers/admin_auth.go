@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/services/matcher"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+)
+
+const redactedValue = "REDACTED"
+
+// adminAuthHandler enforces every configured admin protection: the IP allowlist,
+// and a bearer token when tokens are configured. Client certificates are verified
+// during the TLS handshake when a client CA is configured.
+func adminAuthHandler(adminConfig *config.AdminConfig, next http.Handler) http.Handler {
+	if adminConfig == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := utils.GetLogger()
+
+		if len(adminConfig.AllowedCIDRs) > 0 && !isUnixSocketRequest(r) {
+			clientIP := matcher.ParseClientIP(r.RemoteAddr)
+			if clientIP == nil || !matcher.ContainsIP(adminConfig.AllowedCIDRs, clientIP) {
+				logger.Warn("Admin request from address outside the allowlist", "remote_addr", r.RemoteAddr, "path", r.URL.Path)
+				writeError(w, http.StatusForbidden, "forbidden")
+				return
+			}
+		}
+
+		if len(adminConfig.Tokens) > 0 && !hasValidToken(r, adminConfig.Tokens) {
+			logger.Warn("Unauthorized admin request", "remote_addr", r.RemoteAddr, "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="reproxy"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func hasValidToken(r *http.Request, tokens []string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+
+	valid := false
+	for _, expected := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// isUnixSocketRequest reports whether the request came through the admin unix socket,
+// where access is controlled by file permissions instead of client addresses.
+func isUnixSocketRequest(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+// adminListener opens the admin listener on the unix socket when configured, or on
+// the configured address, falling back to the loopback interface on global.port. An
+// address reachable from other hosts requires tokens, allowed_cidrs or a client CA.
+func adminListener(globalConfig config.GlobalConfig) (net.Listener, error) {
+	adminConfig := globalConfig.Admin
+	if adminConfig != nil && adminConfig.UnixSocket != "" {
+		if err := os.Remove(adminConfig.UnixSocket); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove stale admin socket: %w", err)
+		}
+
+		listener, err := net.Listen("unix", adminConfig.UnixSocket)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(adminConfig.UnixSocket, 0o660); err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+		return listener, nil
+	}
+
+	address := fmt.Sprintf("127.0.0.1:%d", globalConfig.Port)
+	if adminConfig != nil && adminConfig.Address != "" {
+		address = adminConfig.Address
+	}
+
+	if !isLoopbackAddress(address) && !isAdminProtected(adminConfig) {
+		return nil, fmt.Errorf("admin address %s is not a loopback address and neither tokens, allowed_cidrs nor tls.client_ca are configured", address)
+	}
+
+	return net.Listen("tcp", address)
+}
+
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func isAdminProtected(adminConfig *config.AdminConfig) bool {
+	if adminConfig == nil {
+		return false
+	}
+	return len(adminConfig.Tokens) > 0 || len(adminConfig.AllowedCIDRs) > 0 ||
+		(adminConfig.TLS != nil && adminConfig.TLS.ClientCA != "")
+}
+
+func adminTLSConfig(adminConfig *config.AdminConfig) (*tls.Config, error) {
+	if adminConfig == nil || adminConfig.TLS == nil {
+		return nil, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(adminConfig.TLS.CertFile, adminConfig.TLS.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load admin certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+	}
+
+	if adminConfig.TLS.ClientCA != "" {
+		pemData, err := os.ReadFile(adminConfig.TLS.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read admin client CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("no certificates found in admin client CA %q", adminConfig.TLS.ClientCA)
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// redact returns value as generic JSON with the values of the fields tagged
+// redact:"true", such as header values and admin tokens, replaced since those commonly
+// carry credentials.
+func redact(value any) (any, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic any
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		return nil, err
+	}
+
+	redactSecrets(reflect.ValueOf(value), generic)
+	return generic, nil
+}
+
+// redactSecrets walks value along with its generic JSON form and redacts the fields
+// tagged redact:"true".
+func redactSecrets(value reflect.Value, generic any) {
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !value.IsNil() {
+			redactSecrets(value.Elem(), generic)
+		}
+	case reflect.Struct:
+		fields, ok := generic.(map[string]any)
+		if !ok {
+			return
+		}
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if field.Anonymous {
+				redactSecrets(value.Field(i), generic)
+				continue
+			}
+			name := jsonFieldName(field)
+			child, ok := fields[name]
+			if !ok {
+				continue
+			}
+			if field.Tag.Get("redact") == "true" {
+				fields[name] = redactValue(child)
+				continue
+			}
+			redactSecrets(value.Field(i), child)
+		}
+	case reflect.Slice, reflect.Array:
+		items, ok := generic.([]any)
+		if !ok {
+			return
+		}
+		for i := 0; i < value.Len() && i < len(items); i++ {
+			redactSecrets(value.Index(i), items[i])
+		}
+	case reflect.Map:
+		entries, ok := generic.(map[string]any)
+		if !ok || value.Type().Key().Kind() != reflect.String {
+			return
+		}
+		for _, key := range value.MapKeys() {
+			redactSecrets(value.MapIndex(key), entries[key.String()])
+		}
+	}
+}
+
+// jsonFieldName returns the key of a struct field in its JSON encoding.
+func jsonFieldName(field reflect.StructField) string {
+	if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" {
+		return name
+	}
+	return field.Name
+}
+
+// redactValue returns the redacted form of a field: maps keep their keys and lists
+// their length, unset fields stay unset.
+func redactValue(generic any) any {
+	switch v := generic.(type) {
+	case nil:
+		return nil
+	case map[string]any:
+		for name := range v {
+			v[name] = redactedValue
+		}
+		return v
+	case []any:
+		for i := range v {
+			v[i] = redactedValue
+		}
+		return v
+	}
+	return redactedValue
+}
@@ -0,0 +1,554 @@
+package controllers
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/interfaces"
+	"github.com/letronghoangminh/reproxy/pkg/logger"
+	"github.com/letronghoangminh/reproxy/pkg/services/clientip"
+	"github.com/letronghoangminh/reproxy/pkg/services/dns"
+	"github.com/letronghoangminh/reproxy/pkg/services/matcher"
+	"github.com/letronghoangminh/reproxy/pkg/services/metrics"
+	"github.com/letronghoangminh/reproxy/pkg/services/proxy"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+)
+
+type listenerView struct {
+	Port  int      `json:"port"`
+	TLS   bool     `json:"tls"`
+	Hosts []string `json:"hosts"`
+}
+
+type handlerView struct {
+	ID       string                `json:"id"`
+	Name     string                `json:"name,omitempty"`
+	Port     int                   `json:"port"`
+	Host     string                `json:"host"`
+	Index    int                   `json:"index"`
+	Type     string                `json:"type"`
+	Matchers config.MatchersConfig `json:"matchers"`
+}
+
+type backendView struct {
+	URL               string `json:"url"`
+	Weight            int    `json:"weight"`
+	Alive             bool   `json:"alive"`
+	Disabled          bool   `json:"disabled"`
+	Draining          bool   `json:"draining"`
+	Ejected           bool   `json:"ejected"`
+	CircuitBreaker    string `json:"circuit_breaker,omitempty"`
+	ActiveConnections int    `json:"active_connections"`
+}
+
+type transportView struct {
+	OpenConnections   int64 `json:"open_connections"`
+	Dials             int64 `json:"dials"`
+	DialErrors        int64 `json:"dial_errors"`
+	Requests          int64 `json:"requests"`
+	ReusedConnections int64 `json:"reused_connections"`
+}
+
+type upstreamView struct {
+	HandlerID string         `json:"handler_id"`
+	Strategy  string         `json:"strategy"`
+	Backends  []backendView  `json:"backends"`
+	Transport *transportView `json:"transport,omitempty"`
+}
+
+// routeMatchView is the handler a sample request would be routed to, with the handlers
+// of the host in the order they are tried.
+type routeMatchView struct {
+	Port       int               `json:"port"`
+	Host       string            `json:"host"`
+	Handler    string            `json:"handler,omitempty"`
+	PathPrefix string            `json:"path_prefix,omitempty"`
+	Captures   map[string]string `json:"captures,omitempty"`
+	Order      []string          `json:"order"`
+}
+
+type addBackendRequest struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+type logLevelView struct {
+	Level string `json:"level"`
+}
+
+// handlerEntry is a handler as seen from one host of one listener port. A handler
+// declared for several hosts appears once per host but shares its server pool.
+type handlerEntry struct {
+	id      string
+	port    int
+	host    string
+	index   int
+	handler *config.HandlerConfig
+}
+
+func registerAdminRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /listeners", listListeners)
+	mux.HandleFunc("GET /handlers", listHandlers)
+	mux.HandleFunc("GET /upstreams", listUpstreams)
+	mux.HandleFunc("GET /routes/match", matchRoute)
+	mux.HandleFunc("GET /handlers/{id}/backends", getBackends)
+	mux.HandleFunc("POST /handlers/{id}/backends", addBackend)
+	mux.HandleFunc("DELETE /handlers/{id}/backends", removeBackend)
+	mux.HandleFunc("POST /handlers/{id}/backends/{action}", changeBackendState)
+	mux.HandleFunc("POST /handlers/{id}/healthcheck", forceHealthCheck)
+	mux.HandleFunc("DELETE /dns/cache", clearDNSCache)
+	mux.HandleFunc("GET /log/level", getLogLevel)
+	mux.HandleFunc("PUT /log/level", setLogLevel)
+}
+
+func listListeners(w http.ResponseWriter, _ *http.Request) {
+	controllersMutex.RLock()
+	views := make([]listenerView, 0, len(listenerControllers))
+	for port, listenerController := range listenerControllers {
+		hosts := make([]string, 0, len(listenerController.TargetHandler))
+		for host := range listenerController.TargetHandler {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+
+		views = append(views, listenerView{
+			Port:  port,
+			TLS:   listenerController.TLS != nil,
+			Hosts: hosts,
+		})
+	}
+	controllersMutex.RUnlock()
+
+	sort.Slice(views, func(i, j int) bool { return views[i].Port < views[j].Port })
+	writeJSON(w, http.StatusOK, views)
+}
+
+func listHandlers(w http.ResponseWriter, _ *http.Request) {
+	entries := handlerEntries()
+
+	views := make([]handlerView, 0, len(entries))
+	for _, entry := range entries {
+		views = append(views, handlerView{
+			ID:       entry.id,
+			Name:     entry.handler.Name,
+			Port:     entry.port,
+			Host:     entry.host,
+			Index:    entry.index,
+			Type:     handlerType(entry.handler),
+			Matchers: entry.handler.Matchers,
+		})
+	}
+
+	// Header matchers commonly carry credentials, as in the /config endpoint.
+	redacted, err := redact(views)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, redacted)
+}
+
+// matchRoute routes the request described by the url, method, header and remote_addr
+// query parameters without serving it. https URLs are matched as TLS requests whose
+// server name is the URL hostname.
+func matchRoute(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	u, err := url.Parse(query.Get("url"))
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		writeError(w, http.StatusBadRequest, "url must be an absolute http or https URL")
+		return
+	}
+
+	method := query.Get("method")
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	ctx, requestInfo := utils.WithRequestInfo(r.Context())
+	sample, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	for _, header := range query["header"] {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			writeError(w, http.StatusBadRequest, "header must be formatted as Name: value")
+			return
+		}
+		sample.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	sample.RemoteAddr = query.Get("remote_addr")
+	if sample.RemoteAddr == "" {
+		sample.RemoteAddr = "127.0.0.1:0"
+	}
+
+	requestInfo.SetClientIP(clientip.Resolve(sample))
+
+	defaultPort := 80
+	if u.Scheme == "https" {
+		defaultPort = 443
+		sample.TLS = &tls.ConnectionState{ServerName: u.Hostname()}
+	}
+
+	hostname, port, err := matcher.SplitRequestHost(u.Host, defaultPort)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	listenerController, ok := getListenerController(port)
+	if !ok {
+		writeError(w, http.StatusNotFound, "no listener on this port")
+		return
+	}
+
+	host, ok := listenerController.Hosts.Resolve(hostname)
+	if !ok {
+		writeError(w, http.StatusNotFound, "no host of the listener matches")
+		return
+	}
+
+	handlers := listenerController.TargetHandler[host]
+	router := listenerController.Routers[host]
+
+	view := routeMatchView{Port: port, Host: host, Order: []string{}}
+	for _, handler := range router.Handlers() {
+		view.Order = append(view.Order, handlerID(host, port, slices.Index(handlers, handler), handler))
+	}
+
+	if handler := router.Match(sample); handler != nil {
+		view.Handler = handlerID(host, port, slices.Index(handlers, handler), handler)
+		view.PathPrefix = requestInfo.PathPrefix()
+		view.Captures = requestInfo.Captures()
+	}
+
+	writeJSON(w, http.StatusOK, view)
+}
+
+func listUpstreams(w http.ResponseWriter, _ *http.Request) {
+	views := []upstreamView{}
+	for _, entry := range handlerEntries() {
+		serverPool, err := proxy.GetServerPool(entry.handler)
+		if err != nil {
+			continue
+		}
+		views = append(views, newUpstreamView(entry, serverPool))
+	}
+
+	writeJSON(w, http.StatusOK, views)
+}
+
+func getBackends(w http.ResponseWriter, r *http.Request) {
+	entry, ok := findHandlerEntry(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "handler not found")
+		return
+	}
+
+	serverPool, err := proxy.GetServerPool(entry.handler)
+	if err != nil {
+		writeProxyError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newUpstreamView(entry, serverPool))
+}
+
+func addBackend(w http.ResponseWriter, r *http.Request) {
+	entry, ok := findHandlerEntry(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "handler not found")
+		return
+	}
+
+	var body addBackendRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.URL == "" {
+		writeError(w, http.StatusBadRequest, "request body must be a JSON object with a url field")
+		return
+	}
+
+	if body.Weight < 0 {
+		writeError(w, http.StatusBadRequest, "weight must be positive")
+		return
+	}
+
+	backendServer, err := proxy.AddUpstream(entry.handler, body.URL, body.Weight)
+	if err != nil {
+		writeProxyError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, newBackendView(backendServer))
+}
+
+func removeBackend(w http.ResponseWriter, r *http.Request) {
+	entry, ok := findHandlerEntry(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "handler not found")
+		return
+	}
+
+	if err := proxy.RemoveUpstream(entry.handler, r.URL.Query().Get("url")); err != nil {
+		writeProxyError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func changeBackendState(w http.ResponseWriter, r *http.Request) {
+	entry, ok := findHandlerEntry(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "handler not found")
+		return
+	}
+
+	backendURL := r.URL.Query().Get("url")
+
+	var err error
+	switch r.PathValue("action") {
+	case "drain":
+		err = proxy.DrainUpstream(entry.handler, backendURL)
+	case "disable":
+		err = proxy.SetUpstreamDisabled(entry.handler, backendURL, true)
+	case "enable":
+		err = proxy.SetUpstreamDisabled(entry.handler, backendURL, false)
+	default:
+		writeError(w, http.StatusNotFound, "unknown action, expected drain, disable or enable")
+		return
+	}
+
+	if err != nil {
+		writeProxyError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func forceHealthCheck(w http.ResponseWriter, r *http.Request) {
+	entry, ok := findHandlerEntry(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "handler not found")
+		return
+	}
+
+	if err := proxy.RunHealthCheck(r.Context(), entry.handler); err != nil {
+		writeProxyError(w, err)
+		return
+	}
+
+	serverPool, err := proxy.GetServerPool(entry.handler)
+	if err != nil {
+		writeProxyError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newUpstreamView(entry, serverPool))
+}
+
+func clearDNSCache(w http.ResponseWriter, _ *http.Request) {
+	dns.ClearCache()
+	utils.Logger.Info("DNS cache cleared through admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func getLogLevel(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, logLevelView{Level: logger.GetLevel()})
+}
+
+// setLogLevel changes the log level until the next restart, or the next reload that
+// changes global.log_level.
+func setLogLevel(w http.ResponseWriter, r *http.Request) {
+	var body logLevelView
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "request body must be a JSON object with a level field")
+		return
+	}
+
+	previous := logger.GetLevel()
+	if err := logger.SetLevel(body.Level); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.Logger.Warn("Log level changed through admin API", "previous_level", previous, "level", body.Level)
+	writeJSON(w, http.StatusOK, logLevelView{Level: logger.GetLevel()})
+}
+
+// handlerEntries lists every handler of every listener, ordered by port and host.
+// Handlers are identified by their name, or by host:port:index when unnamed.
+func handlerEntries() []handlerEntry {
+	controllersMutex.RLock()
+	defer controllersMutex.RUnlock()
+
+	entries := []handlerEntry{}
+	for port, listenerController := range listenerControllers {
+		for host, handlers := range listenerController.TargetHandler {
+			for index, handler := range handlers {
+				entries = append(entries, handlerEntry{
+					id:      handlerID(host, port, index, handler),
+					port:    port,
+					host:    host,
+					index:   index,
+					handler: handler,
+				})
+			}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].port != entries[j].port {
+			return entries[i].port < entries[j].port
+		}
+		if entries[i].host != entries[j].host {
+			return entries[i].host < entries[j].host
+		}
+		return entries[i].index < entries[j].index
+	})
+
+	return entries
+}
+
+// handlerID identifies a handler by its name, or by host:port:index when unnamed.
+func handlerID(host string, port, index int, handler *config.HandlerConfig) string {
+	if handler.Name != "" {
+		return handler.Name
+	}
+	return fmt.Sprintf("%s:%d:%d", host, port, index)
+}
+
+// backendStats lists the backends of every reverse proxy handler for the metrics
+// endpoint. Handlers shared by several hosts are reported once.
+func backendStats() []metrics.BackendStat {
+	stats := []metrics.BackendStat{}
+	seen := map[*config.HandlerConfig]bool{}
+
+	for _, entry := range handlerEntries() {
+		if seen[entry.handler] {
+			continue
+		}
+		seen[entry.handler] = true
+
+		serverPool, err := proxy.GetServerPool(entry.handler)
+		if err != nil {
+			continue
+		}
+
+		for _, b := range serverPool.GetBackends() {
+			stats = append(stats, metrics.BackendStat{
+				Handler:           entry.id,
+				Upstream:          b.GetURL().Host,
+				ActiveConnections: b.GetActiveConnections(),
+				Alive:             b.IsAlive(),
+			})
+		}
+	}
+
+	return stats
+}
+
+func findHandlerEntry(id string) (handlerEntry, bool) {
+	for _, entry := range handlerEntries() {
+		if entry.id == id {
+			return entry, true
+		}
+	}
+	return handlerEntry{}, false
+}
+
+func handlerType(handler *config.HandlerConfig) string {
+	switch {
+	case handler.StaticResponse.Body != "":
+		return "static_response"
+	case handler.StaticFiles.Root != "":
+		return "static_files"
+	case len(handler.ReverseProxy.Upstreams.Dynamic) > 0 || len(handler.ReverseProxy.Upstreams.Static) > 0:
+		return "reverse_proxy"
+	default:
+		return "none"
+	}
+}
+
+func newUpstreamView(entry handlerEntry, serverPool interfaces.ServerPool) upstreamView {
+	strategy := entry.handler.ReverseProxy.LoadBalancing.Strategy
+	if strategy == "" {
+		strategy = "round_robin"
+	}
+
+	backends := serverPool.GetBackends()
+	views := make([]backendView, 0, len(backends))
+	for _, b := range backends {
+		views = append(views, newBackendView(b))
+	}
+
+	view := upstreamView{
+		HandlerID: entry.id,
+		Strategy:  strategy,
+		Backends:  views,
+	}
+	if stats, err := proxy.GetTransportStats(entry.handler); err == nil {
+		view.Transport = &transportView{
+			OpenConnections:   stats.OpenConnections,
+			Dials:             stats.Dials,
+			DialErrors:        stats.DialErrors,
+			Requests:          stats.Requests,
+			ReusedConnections: stats.ReusedConnections,
+		}
+	}
+	return view
+}
+
+func newBackendView(b interfaces.Backend) backendView {
+	return backendView{
+		URL:               b.GetURL().String(),
+		Weight:            b.GetWeight(),
+		Alive:             b.IsAlive(),
+		Disabled:          b.IsDisabled(),
+		Draining:          b.IsDraining(),
+		Ejected:           b.IsEjected(),
+		CircuitBreaker:    b.GetCircuitState(),
+		ActiveConnections: b.GetActiveConnections(),
+	}
+}
+
+func writeProxyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, proxy.ErrUpstreamGroupNotFound), errors.Is(err, proxy.ErrBackendNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, proxy.ErrBackendExists):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		utils.Logger.Error("error occurred while marshalling admin response", "error", err)
+		http.Error(w, "error occurred while marshalling response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+)
+
+// withListenerControllers installs controllers for the duration of a test.
+func withListenerControllers(t *testing.T, controllers map[int]ListenerController) {
+	t.Helper()
+
+	controllersMutex.Lock()
+	previous := listenerControllers
+	listenerControllers = controllers
+	controllersMutex.Unlock()
+
+	t.Cleanup(func() {
+		controllersMutex.Lock()
+		listenerControllers = previous
+		controllersMutex.Unlock()
+	})
+}
+
+func TestListHandlersRedactsHeaderMatchers(t *testing.T) {
+	handler := &config.HandlerConfig{
+		Name: "api",
+		Matchers: config.MatchersConfig{
+			Path:    "/api",
+			Headers: map[string]string{"Authorization": "Bearer secret-token"},
+		},
+	}
+	withListenerControllers(t, map[int]ListenerController{
+		8080: {Port: 8080, TargetHandler: map[string][]*config.HandlerConfig{"example.com": {handler}}},
+	})
+
+	recorder := httptest.NewRecorder()
+	listHandlers(recorder, httptest.NewRequest("GET", "/handlers", nil))
+
+	body := recorder.Body.String()
+	if recorder.Code != 200 {
+		t.Fatalf("status = %d, body %s", recorder.Code, body)
+	}
+	if strings.Contains(body, "secret-token") {
+		t.Errorf("header matcher value was exposed: %s", body)
+	}
+	for _, want := range []string{`"Authorization": "` + redactedValue + `"`, `"Path": "/api"`, `"id": "api"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("response does not contain %s: %s", want, body)
+		}
+	}
+}
+
+func TestRedactConfig(t *testing.T) {
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Admin: &config.AdminConfig{Tokens: []string{"admin-secret-token"}}},
+		Listeners: []config.ListenerConfig{{
+			Handlers: []config.HandlerConfig{{
+				Matchers: config.MatchersConfig{Headers: map[string]string{"X-Api-Key": "api-secret"}},
+			}},
+		}},
+	}
+
+	redacted, err := redact(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	writeJSON(recorder, 200, redacted)
+
+	body := recorder.Body.String()
+	for _, secret := range []string{"admin-secret-token", "api-secret"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("%s was exposed: %s", secret, body)
+		}
+	}
+	if !strings.Contains(body, `"X-Api-Key": "`+redactedValue+`"`) {
+		t.Errorf("header names must be kept: %s", body)
+	}
+}
@@ -2,58 +2,73 @@ package controllers
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"sync"
 
 	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/services/metrics"
 	"github.com/letronghoangminh/reproxy/pkg/utils"
 )
 
-var (
-	cfg *config.Config
-)
-
 func retrieveConfig(w http.ResponseWriter, r *http.Request) {
 	utils.Logger.Info("requesting for retrieving server config", "method", r.Method, "path", r.URL.Path)
 
-	jsonCfg, err := json.MarshalIndent(cfg, "", "  ")
+	redacted, err := redact(config.GetConfig())
 	if err != nil {
 		utils.Logger.Error("error occurred while marshalling config", "error", err)
 		http.Error(w, "error occurred while marshalling config", http.StatusInternalServerError)
 		return
 	}
 
-	_, _ = w.Write(jsonCfg)
+	writeJSON(w, http.StatusOK, redacted)
 }
 
 func DefaultControllerServe(ctx context.Context, wg *sync.WaitGroup) {
-	cfg = config.GetConfig()
-	port := cfg.Global.Port
+	globalConfig := config.GetConfig().Global
 
-	http.HandleFunc("/config", retrieveConfig)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config", retrieveConfig)
+	registerAdminRoutes(mux)
+	mux.Handle("GET /metrics", metrics.Handler())
+	metrics.SetBackendSource(backendStats)
 
-	utils.Logger.Info("default controller is serving", "port", port)
+	listener, err := adminListener(globalConfig)
+	if err != nil {
+		utils.Logger.Fatal("error occurred while opening default controller listener", "error", err)
+	}
+
+	tlsConfig, err := adminTLSConfig(globalConfig.Admin)
+	if err != nil {
+		utils.Logger.Fatal("error occurred while loading default controller TLS configuration", "error", err)
+	}
+
+	address := listener.Addr().String()
+	utils.Logger.Info("default controller is serving", "address", address)
 
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%v", port),
-		Handler: nil,
+		Handler:   adminAuthHandler(globalConfig.Admin, mux),
+		TLSConfig: tlsConfig,
+	}
+	var adminTimeouts *config.ServerTimeoutsConfig
+	if globalConfig.Admin != nil {
+		adminTimeouts = globalConfig.Admin.Timeouts
 	}
+	newServerTimeouts(adminTimeouts).apply(server)
 
 	wg.Add(1)
 	go func() {
-		utils.Logger.Info("serving default controller", "port", port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			utils.Logger.Error(fmt.Sprintf("error occurred while serving default controller on port %d", port), "error", err)
+		utils.Logger.Info("serving default controller", "address", address, "tls", tlsConfig != nil)
+		if err := serve(server, listener, tlsConfig != nil); err != nil && err != http.ErrServerClosed {
+			utils.Logger.Error(fmt.Sprintf("error occurred while serving default controller on %s", address), "error", err)
 		}
 	}()
 
 	go func() {
 		<-ctx.Done()
-		utils.Logger.Info("shutting down default controller", "port", port)
+		utils.Logger.Info("shutting down default controller", "address", address)
 		if err := server.Shutdown(context.Background()); err != nil {
-			utils.Logger.Error(fmt.Sprintf("error shutting down default controller on port %d", port), "error", err)
+			utils.Logger.Error(fmt.Sprintf("error shutting down default controller on %s", address), "error", err)
 		}
 		wg.Done()
 	}()
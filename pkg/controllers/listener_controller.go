@@ -4,16 +4,25 @@ package controllers
 import (
 	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/interfaces"
+	"github.com/letronghoangminh/reproxy/pkg/services/accesslog"
+	"github.com/letronghoangminh/reproxy/pkg/services/certs"
+	"github.com/letronghoangminh/reproxy/pkg/services/clientip"
 	"github.com/letronghoangminh/reproxy/pkg/services/matcher"
+	"github.com/letronghoangminh/reproxy/pkg/services/metrics"
 	"github.com/letronghoangminh/reproxy/pkg/services/proxy"
+	"github.com/letronghoangminh/reproxy/pkg/services/proxyprotocol"
 	"github.com/letronghoangminh/reproxy/pkg/services/static"
 	"github.com/letronghoangminh/reproxy/pkg/utils"
 )
@@ -22,6 +31,20 @@ type ListenerController struct {
 	Server        *http.ServeMux
 	Port          int
 	TargetHandler map[string][]*config.HandlerConfig
+	Routers       map[string]interfaces.Matcher
+	Hosts         *matcher.HostRouter
+	TLS           *certs.CertificateStore
+	Timeouts      serverTimeouts
+	ProxyProtocol bool
+
+	// HostAccessLogs holds the listener access log of each host, used for requests
+	// that match no handler, and HandlerAccessLogs the access log of every handler.
+	// AccessLog is the first listener access log of the port, used for requests
+	// rejected before their host is resolved. A nil logger means access logging is
+	// disabled.
+	HostAccessLogs    map[string]*accesslog.AccessLogger
+	HandlerAccessLogs map[*config.HandlerConfig]*accesslog.AccessLogger
+	AccessLog         *accesslog.AccessLogger
 }
 
 type gzipResponseWriter struct {
@@ -33,75 +56,565 @@ func (gzw *gzipResponseWriter) Write(b []byte) (int, error) {
 	return gzw.Writer.Write(b)
 }
 
+// Flush sends the compressed data written so far, for streamed responses.
+func (gzw *gzipResponseWriter) Flush() {
+	_ = gzw.Writer.Flush()
+	_ = http.NewResponseController(gzw.ResponseWriter).Flush()
+}
+
+func (gzw *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return gzw.ResponseWriter
+}
+
+type listenerServer struct {
+	useTLS        bool
+	timeouts      serverTimeouts
+	proxyProtocol bool
+	stop          context.CancelFunc
+	closed        <-chan struct{}
+}
+
+// closeNotifyListener reports when the server closed it, which happens at the start of
+// a graceful shutdown, so the port can be bound again while connections drain.
+type closeNotifyListener struct {
+	net.Listener
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (l *closeNotifyListener) Close() error {
+	err := l.Listener.Close()
+	l.once.Do(func() {
+		close(l.closed)
+	})
+	return err
+}
+
 var (
 	listenerControllers map[int]ListenerController
+	listenerServers     = map[int]listenerServer{}
+	controllersMutex    sync.RWMutex
+	reloadMutex         sync.Mutex
+	stopGeneration      context.CancelFunc
 )
 
 func InitListenerControllers(ctx context.Context, wg *sync.WaitGroup) {
-	listenerControllers = map[int]ListenerController{}
+	if err := ApplyConfig(ctx, wg, config.GetConfig()); err != nil {
+		utils.Logger.Fatal("error occurred while initializing listener controllers", "error", err)
+	}
+}
+
+// ApplyConfig builds listener controllers, certificates and load balancers for cfg and
+// swaps them in atomically. Servers on ports present in both the old and the new
+// configuration keep running and only see the new routing table, ports that were
+// added are opened and ports that were removed are shut down gracefully. If anything
+// fails the previous configuration keeps serving, apart from a restarted port that
+// could not be bound again.
+func ApplyConfig(ctx context.Context, wg *sync.WaitGroup, cfg *config.Config) error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	generationCtx, cancel := context.WithCancel(ctx)
+
+	controllers, reverseProxyHandlers, err := buildListenerControllers(cfg)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("error occurred while building listener controllers: %w", err)
+	}
+
+	if err := loadCertificates(generationCtx, cfg, controllers); err != nil {
+		cancel()
+		return fmt.Errorf("error occurred while loading TLS certificates: %w", err)
+	}
+
+	if err := loadAccessLogs(cfg, controllers); err != nil {
+		cancel()
+		return fmt.Errorf("error occurred while opening access logs: %w", err)
+	}
+
+	if err := loadServerTimeouts(cfg, controllers); err != nil {
+		cancel()
+		return fmt.Errorf("error occurred while loading server timeouts: %w", err)
+	}
+
+	if err := loadProxyProtocol(cfg, controllers); err != nil {
+		cancel()
+		return fmt.Errorf("error occurred while loading PROXY protocol settings: %w", err)
+	}
+
+	newListeners := map[int]net.Listener{}
+	closeNewListeners := func() {
+		for _, l := range newListeners {
+			_ = l.Close()
+		}
+	}
+
+	for port := range controllers {
+		if _, ok := listenerServers[port]; ok {
+			continue
+		}
+
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			closeNewListeners()
+			cancel()
+			return fmt.Errorf("error occurred while listening on port %d: %w", port, err)
+		}
+		newListeners[port] = listener
+	}
+
+	if err := proxy.StartLoadBalancers(generationCtx, reverseProxyHandlers); err != nil {
+		closeNewListeners()
+		cancel()
+		return err
+	}
+
+	// Ports whose TLS mode, timeouts or PROXY protocol setting changed cannot be
+	// switched in place, so they are restarted. The old server has to release the port
+	// first. If the port cannot be bound again the reload fails, and the ports restarted
+	// so far serve the previous controllers again.
+	restartedListeners := map[int]net.Listener{}
+	for port, running := range listenerServers {
+		controller, ok := controllers[port]
+		if !ok || (running.useTLS == (controller.TLS != nil) && running.timeouts == controller.Timeouts &&
+			running.proxyProtocol == controller.ProxyProtocol) {
+			continue
+		}
+
+		utils.Logger.Info("restarting controller after TLS mode, timeout or PROXY protocol change", "port", port)
+		running.stop()
+		<-running.closed
+		delete(listenerServers, port)
+
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			for restartedPort, restartedListener := range restartedListeners {
+				previousController, _ := getListenerController(restartedPort)
+				serveController(ctx, wg, restartedPort, restartedListener, previousController)
+			}
+			closeNewListeners()
+			proxy.RestoreLoadBalancers()
+			cancel()
+			return fmt.Errorf("error occurred while listening on port %d: %w", port, err)
+		}
+		restartedListeners[port] = listener
+	}
+	for port, listener := range restartedListeners {
+		newListeners[port] = listener
+	}
+
+	proxy.SetRetryBudget(cfg.Global.RetryBudget)
+	clientip.SetTrustedProxies(&cfg.Global)
+
+	controllersMutex.Lock()
+	listenerControllers = controllers
+	controllersMutex.Unlock()
+	config.SetConfig(cfg)
+
+	for port, listener := range newListeners {
+		serveController(ctx, wg, port, listener, controllers[port])
+	}
+
+	for port, running := range listenerServers {
+		if _, ok := controllers[port]; !ok {
+			utils.Logger.Info("closing controller removed from configuration", "port", port)
+			running.stop()
+			delete(listenerServers, port)
+		}
+	}
+
+	if stopGeneration != nil {
+		stopGeneration()
+	}
+	stopGeneration = cancel
+
+	return nil
+}
+
+func buildListenerControllers(cfg *config.Config) (map[int]ListenerController, []*config.HandlerConfig, error) {
+	controllers := map[int]ListenerController{}
 	reverseProxyHandlers := []*config.HandlerConfig{}
 
 	utils.Logger.Info("parsing listener configs")
-	listeners := combineListener()
+	listeners, err := combineListener(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkHandlerNames(listeners); err != nil {
+		return nil, nil, err
+	}
 
-	for host, handlers := range listeners {
+	for _, listener := range listeners {
 		utils.Logger.Info("constructing listener controllers")
 
-		port, _ := strconv.Atoi(strings.Split(host, ":")[1])
-		hostname := strings.Split(host, ":")[0]
-
-		_, ok := listenerControllers[port]
+		_, ok := controllers[listener.port]
 		if !ok {
-			utils.Logger.Info("initializing new listener controller", "port", port)
-			listenerControllers[port] = ListenerController{
-				Server:        http.NewServeMux(),
-				Port:          port,
-				TargetHandler: map[string][]*config.HandlerConfig{},
+			utils.Logger.Info("initializing new listener controller", "port", listener.port)
+			controllers[listener.port] = newListenerController(listener.port)
+		}
+
+		for _, handler := range listener.handlers {
+			if len(handler.ReverseProxy.Upstreams.Dynamic) > 0 || len(handler.ReverseProxy.Upstreams.Static) > 0 {
+				reverseProxyHandlers = append(reverseProxyHandlers, handler)
 			}
-			listenerControllers[port].Server.HandleFunc("/", gzipHandler(defaultHandler))
 		}
+		controllers[listener.port].TargetHandler[listener.hostname] = listener.handlers
+		if err := controllers[listener.port].Hosts.Add(listener.hostname); err != nil {
+			return nil, nil, err
+		}
+
+		router, err := matcher.NewRouter(listener.handlers)
+		if err != nil {
+			return nil, nil, fmt.Errorf("host %s: %w", net.JoinHostPort(listener.hostname, strconv.Itoa(listener.port)), err)
+		}
+		controllers[listener.port].Routers[listener.hostname] = router
+		warnShadowedHandlers(listener, router.Shadowed())
+	}
+
+	return controllers, reverseProxyHandlers, nil
+}
+
+// checkHandlerNames rejects handler names used by more than one handler, as handlers
+// are identified by their name in the admin API, logs and metrics. A handler shared by
+// several hosts keeps its name.
+func checkHandlerNames(listeners []*listenerHost) error {
+	named := map[string]*config.HandlerConfig{}
+	for _, listener := range listeners {
+		for _, handler := range listener.handlers {
+			if handler.Name == "" {
+				continue
+			}
+			if other, ok := named[handler.Name]; ok && other != handler {
+				return fmt.Errorf("handler name %q is used by more than one handler", handler.Name)
+			}
+			named[handler.Name] = handler
+		}
+	}
+	return nil
+}
+
+// warnShadowedHandlers logs the handlers of a host that can never match.
+func warnShadowedHandlers(listener *listenerHost, shadows []matcher.Shadow) {
+	for _, shadow := range shadows {
+		utils.Logger.Warn("handler is shadowed by a higher ranked handler and never matches",
+			"host", net.JoinHostPort(listener.hostname, strconv.Itoa(listener.port)),
+			"handler", handlerID(listener.hostname, listener.port, slices.Index(listener.handlers, shadow.Handler), shadow.Handler),
+			"shadowed_by", handlerID(listener.hostname, listener.port, slices.Index(listener.handlers, shadow.By), shadow.By))
+	}
+}
+
+func newListenerController(port int) ListenerController {
+	listenerController := ListenerController{
+		Server:            http.NewServeMux(),
+		Port:              port,
+		TargetHandler:     map[string][]*config.HandlerConfig{},
+		Routers:           map[string]interfaces.Matcher{},
+		Hosts:             matcher.NewHostRouter(),
+		Timeouts:          newServerTimeouts(nil),
+		HostAccessLogs:    map[string]*accesslog.AccessLogger{},
+		HandlerAccessLogs: map[*config.HandlerConfig]*accesslog.AccessLogger{},
+	}
+	listenerController.Server.HandleFunc("/", gzipHandler(defaultHandler))
+	return listenerController
+}
+
+// serveController starts the server of a port. The server always dispatches to the
+// current listener controller of the port, so a reload only has to swap the map.
+func serveController(ctx context.Context, wg *sync.WaitGroup, port int, listener net.Listener, listenerController ListenerController) {
+	useTLS := listenerController.TLS != nil
+	timeouts := listenerController.Timeouts
+
+	serverCtx, stop := context.WithCancel(ctx)
+	trackedListener := &closeNotifyListener{
+		Listener: listener,
+		closed:   make(chan struct{}),
+	}
+
+	// The PROXY protocol header precedes the TLS handshake.
+	var servedListener net.Listener = trackedListener
+	if listenerController.ProxyProtocol {
+		servedListener = &proxyprotocol.Listener{
+			Listener:      trackedListener,
+			HeaderTimeout: timeouts.readHeader,
+			Trusted:       trustedProxyProtocolPeer,
+		}
+	}
 
-		handlerPointers := make([]*config.HandlerConfig, len(handlers))
-		for i := range handlers {
-			handlerPointers[i] = &handlers[i]
-			if len(handlers[i].ReverseProxy.Upstreams.Dynamic) > 0 || len(handlers[i].ReverseProxy.Upstreams.Static) > 0 {
-				reverseProxyHandlers = append(reverseProxyHandlers, &handlers[i])
+	server := &http.Server{
+		Addr: fmt.Sprintf(":%d", port),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			listenerController, ok := getListenerController(port)
+			if !ok {
+				http.Error(w, "Not Found", http.StatusNotFound)
+				return
 			}
+			listenerController.Server.ServeHTTP(w, r)
+		}),
+	}
+	timeouts.apply(server)
+	if useTLS {
+		server.TLSConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			NextProtos: []string{"h2", "http/1.1"},
+			GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				listenerController, ok := getListenerController(port)
+				if !ok || listenerController.TLS == nil {
+					return nil, fmt.Errorf("no TLS configuration for port %d", port)
+				}
+				return listenerController.TLS.GetConfigForClient(hello)
+			},
 		}
-		listenerControllers[port].TargetHandler[hostname] = handlerPointers
 	}
 
-	proxy.StartLoadBalancers(ctx, reverseProxyHandlers)
+	listenerServers[port] = listenerServer{
+		useTLS:        useTLS,
+		timeouts:      timeouts,
+		proxyProtocol: listenerController.ProxyProtocol,
+		stop:          stop,
+		closed:        trackedListener.closed,
+	}
 
-	for port, listenerController := range listenerControllers {
-		port := port
-		server := &http.Server{
-			Addr:    fmt.Sprintf(":%d", port),
-			Handler: listenerController.Server,
+	wg.Add(1)
+	go func() {
+		utils.Logger.Info("serving new controller", "port", port, "tls", useTLS)
+		if err := serve(server, servedListener, useTLS); err != nil && err != http.ErrServerClosed {
+			utils.Logger.Error(fmt.Sprintf("error occurred while serving controller on port %d", port), "error", err)
 		}
+	}()
+
+	go func() {
+		<-serverCtx.Done()
+		utils.Logger.Info("shutting down controller", "port", port)
+		if err := server.Shutdown(context.Background()); err != nil {
+			utils.Logger.Error(fmt.Sprintf("error shutting down controller on port %d", port), "error", err)
+		}
+		proxy.CloseTunnels(port)
+		wg.Done()
+	}()
+}
+
+func getListenerController(port int) (ListenerController, bool) {
+	controllersMutex.RLock()
+	defer controllersMutex.RUnlock()
 
-		wg.Add(1)
-		go func() {
-			utils.Logger.Info("serving new controller", "port", port)
-			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				utils.Logger.Error(fmt.Sprintf("error occurred while serving controller on port %d", port), "error", err)
+	listenerController, ok := listenerControllers[port]
+	return listenerController, ok
+}
+
+// loadCertificates builds one certificate store per port from the listener blocks that
+// declare a tls section, so that every host sharing a port is selected through SNI.
+func loadCertificates(ctx context.Context, cfg *config.Config, controllers map[int]ListenerController) error {
+	var acmeManager *certs.ACMEManager
+
+	for _, listenerConfig := range cfg.Listeners {
+		if listenerConfig.TLS == nil {
+			continue
+		}
+
+		if listenerConfig.TLS.ACME && acmeManager == nil {
+			var err error
+			acmeManager, err = certs.NewACMEManager(cfg.Global.ACME, utils.Logger)
+			if err != nil {
+				return err
 			}
-		}()
+		}
 
-		go func() {
-			<-ctx.Done()
-			utils.Logger.Info("shutting down controller", "port", port)
-			if err := server.Shutdown(context.Background()); err != nil {
-				utils.Logger.Error(fmt.Sprintf("error shutting down controller on port %d", port), "error", err)
+		hostnamesByPort := map[int][]string{}
+		for _, host := range listenerConfig.Host {
+			hostname, port, err := matcher.SplitListenerHost(host)
+			if err != nil {
+				return err
 			}
-			wg.Done()
-		}()
+			// Regex hosts and the default server are served with the certificates matching
+			// the server name or the fallback certificate.
+			hostnames := hostnamesByPort[port]
+			if !strings.HasPrefix(hostname, "~") && hostname != matcher.CatchAllHost {
+				hostnames = append(hostnames, hostname)
+			}
+			hostnamesByPort[port] = hostnames
+		}
+
+		for port, hostnames := range hostnamesByPort {
+			listenerController := controllers[port]
+			if listenerController.TLS == nil {
+				listenerController.TLS = certs.NewCertificateStore(utils.Logger)
+				controllers[port] = listenerController
+			}
+
+			if err := listenerController.TLS.AddListener(hostnames, listenerConfig.TLS, acmeManager); err != nil {
+				return fmt.Errorf("listener %v: %w", listenerConfig.Host, err)
+			}
+		}
+	}
+
+	if acmeManager != nil {
+		registerACMEChallenges(cfg, controllers, acmeManager)
+		// Loaded before the controllers are swapped in, so that a reload does not
+		// serve ACME hosts without their certificates.
+		acmeManager.LoadStoredCertificates()
+		go acmeManager.Run(ctx)
+	}
+
+	for port, listenerController := range controllers {
+		if listenerController.TLS == nil {
+			continue
+		}
+		for host := range listenerController.TargetHandler {
+			if !hasTLS(cfg, host, port) {
+				utils.Logger.Warn("host shares a TLS port but has no tls section, serving it with the fallback certificate",
+					"host", host, "port", port)
+			}
+		}
+	}
+
+	return nil
+}
+
+// registerACMEChallenges serves HTTP-01 challenges from the listener mux on the
+// challenge port, creating a plain HTTP listener there if none is configured.
+func registerACMEChallenges(cfg *config.Config, controllers map[int]ListenerController, acmeManager *certs.ACMEManager) {
+	port := 80
+	if cfg.Global.ACME != nil && cfg.Global.ACME.HTTPPort != 0 {
+		port = cfg.Global.ACME.HTTPPort
+	}
+
+	listenerController, ok := controllers[port]
+	if !ok {
+		utils.Logger.Info("initializing listener controller for ACME challenges", "port", port)
+		listenerController = newListenerController(port)
+		controllers[port] = listenerController
+	}
+
+	if listenerController.TLS != nil {
+		utils.Logger.Warn("ACME HTTP-01 challenges are served on a TLS port", "port", port)
+	}
+
+	listenerController.Server.Handle(certs.ACMEChallengePrefix, acmeManager.HTTPHandler(gzipHandler(defaultHandler)))
+}
+
+// loadAccessLogs opens the access log of every listener and handler. A handler uses its
+// own access_log section when it has one, and the one of its listener otherwise.
+func loadAccessLogs(cfg *config.Config, controllers map[int]ListenerController) error {
+	accessLoggers := map[*config.AccessLogConfig]*accesslog.AccessLogger{}
+	open := func(accessLogConfig *config.AccessLogConfig) (*accesslog.AccessLogger, error) {
+		if accessLogConfig == nil || accessLogConfig.Disabled {
+			return nil, nil
+		}
+		if accessLogger, ok := accessLoggers[accessLogConfig]; ok {
+			return accessLogger, nil
+		}
+		accessLogger, err := accesslog.NewAccessLogger(accessLogConfig)
+		if err != nil {
+			return nil, err
+		}
+		accessLoggers[accessLogConfig] = accessLogger
+		return accessLogger, nil
+	}
+
+	for i := range cfg.Listeners {
+		listenerConfig := &cfg.Listeners[i]
+
+		listenerAccessLogger, err := open(listenerConfig.AccessLog)
+		if err != nil {
+			return fmt.Errorf("listener %v: %w", listenerConfig.Host, err)
+		}
+
+		handlerAccessLoggers := make(map[*config.HandlerConfig]*accesslog.AccessLogger, len(listenerConfig.Handlers))
+		for j := range listenerConfig.Handlers {
+			handler := &listenerConfig.Handlers[j]
+
+			handlerAccessLoggers[handler] = listenerAccessLogger
+			if handler.AccessLog != nil {
+				handlerAccessLoggers[handler], err = open(handler.AccessLog)
+				if err != nil {
+					return fmt.Errorf("listener %v: %w", listenerConfig.Host, err)
+				}
+			}
+		}
+
+		for _, host := range listenerConfig.Host {
+			hostname, port, err := matcher.SplitListenerHost(host)
+			if err != nil {
+				return err
+			}
+
+			// Several listener blocks can declare the same host, the first access log wins.
+			listenerController := controllers[port]
+			if listenerController.HostAccessLogs[hostname] == nil {
+				listenerController.HostAccessLogs[hostname] = listenerAccessLogger
+			}
+			if listenerController.AccessLog == nil {
+				listenerController.AccessLog = listenerAccessLogger
+			}
+			for handler, handlerAccessLogger := range handlerAccessLoggers {
+				listenerController.HandlerAccessLogs[handler] = handlerAccessLogger
+			}
+			controllers[port] = listenerController
+		}
+	}
+
+	return nil
+}
+
+// loadProxyProtocol enables the PROXY protocol on the ports of every listener block
+// that sets proxy_protocol. A port either requires the header or does not, so it
+// applies to all the hosts of the port.
+func loadProxyProtocol(cfg *config.Config, controllers map[int]ListenerController) error {
+	for _, listenerConfig := range cfg.Listeners {
+		if !listenerConfig.ProxyProtocol {
+			continue
+		}
+
+		for _, host := range listenerConfig.Host {
+			_, port, err := matcher.SplitListenerHost(host)
+			if err != nil {
+				return err
+			}
+
+			listenerController := controllers[port]
+			listenerController.ProxyProtocol = true
+			controllers[port] = listenerController
+		}
 	}
+
+	return nil
+}
+
+// trustedProxyProtocolPeer only lets trusted proxies send PROXY protocol headers, or
+// every peer when trusted_proxies is not set.
+func trustedProxyProtocolPeer(ip net.IP) bool {
+	return !clientip.HasTrustedProxies() || clientip.IsTrusted(ip)
+}
+
+func hasTLS(cfg *config.Config, hostname string, port int) bool {
+	for _, listenerConfig := range cfg.Listeners {
+		if listenerConfig.TLS == nil {
+			continue
+		}
+		for _, host := range listenerConfig.Host {
+			if h, p, err := matcher.SplitListenerHost(host); err == nil && h == hostname && p == port {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func serve(server *http.Server, listener net.Listener, useTLS bool) error {
+	if useTLS {
+		return server.ServeTLS(listener, "", "")
+	}
+	return server.Serve(listener)
 }
 
 func gzipHandler(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		// Upgraded connections carry their own protocol and must reach the hijacker.
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || utils.IsUpgradeRequest(r) {
 			next(w, r)
 			return
 		}
@@ -129,29 +642,47 @@ func gzipHandler(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func combineListener() map[string][]config.HandlerConfig {
-	listeners := map[string][]config.HandlerConfig{}
+// listenerHost is a host pattern of a port with the handlers of every listener block
+// declaring it, in configuration order.
+type listenerHost struct {
+	hostname string
+	port     int
+	handlers []*config.HandlerConfig
+}
 
-	for _, listenerConfig := range cfg.Listeners {
+// combineListener groups the handlers of every host:port. The handlers point into cfg,
+// so a handler can be traced back to the listener block that declares it.
+func combineListener(cfg *config.Config) ([]*listenerHost, error) {
+	listeners := []*listenerHost{}
+	byHost := map[string]*listenerHost{}
+
+	for i := range cfg.Listeners {
+		listenerConfig := &cfg.Listeners[i]
 		for _, host := range listenerConfig.Host {
-			handlers := listenerConfig.Handlers
+			hostname, port, err := matcher.SplitListenerHost(host)
+			if err != nil {
+				return nil, err
+			}
 
-			_, ok := listeners[host]
+			key := net.JoinHostPort(hostname, strconv.Itoa(port))
+			listener, ok := byHost[key]
 			if !ok {
-				listeners[host] = handlers
-				continue
+				listener = &listenerHost{hostname: hostname, port: port}
+				byHost[key] = listener
+				listeners = append(listeners, listener)
+			}
+			for j := range listenerConfig.Handlers {
+				listener.handlers = append(listener.handlers, &listenerConfig.Handlers[j])
 			}
-
-			listeners[host] = append(listeners[host], handlers...)
 		}
 	}
 
-	return listeners
+	return listeners, nil
 }
 
 func defaultHandler(w http.ResponseWriter, r *http.Request) {
 	logger := utils.GetLogger()
-	logger.Info("Request received",
+	logger.Debug("Request received",
 		"path", r.URL.Path,
 		"host", r.Host,
 		"method", r.Method,
@@ -165,44 +696,80 @@ func defaultHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	logger = logger.With("request_id", requestID)
 
-	var port int
-	var host string
+	start := time.Now()
+	recorder := newResponseRecorder(w)
+	w = recorder
 
-	if strings.Contains(r.Host, ":") {
-		var err error
-		var portStr string
-		host, portStr, err = net.SplitHostPort(r.Host)
-		if err != nil {
-			logger.Error("Failed to parse host and port", "host", r.Host, "error", err)
-			http.Error(w, "Bad Request", http.StatusBadRequest)
-			return
-		}
-		port, err = strconv.Atoi(portStr)
-		if err != nil {
-			logger.Error("Invalid port number", "port", portStr, "error", err)
-			http.Error(w, "Bad Request", http.StatusBadRequest)
-			return
+	ctx, requestInfo := utils.WithRequestInfo(r.Context())
+	r = r.WithContext(ctx)
+	requestInfo.SetClientIP(clientip.Resolve(r))
+
+	listenerPort := localPort(r)
+	hostLabel, handlerLabel := "unknown", "none"
+
+	var accessLogger *accesslog.AccessLogger
+	var accessEntry *accesslog.Entry
+	if listenerController, ok := getListenerController(listenerPort); ok {
+		accessLogger = listenerController.AccessLog
+	}
+
+	defer func() {
+		duration := time.Since(start)
+		metrics.ObserveRequest(listenerPort, hostLabel, handlerLabel, requestInfo.Upstream(), recorder.Status(), duration)
+
+		if accessLogger != nil {
+			// Requests rejected before their host is resolved were not handled, so
+			// their fields can still be captured.
+			if accessEntry == nil {
+				accessEntry = accesslog.NewEntry(r, start, requestID)
+			}
+			accessEntry.Port = listenerPort
+			accessEntry.Handler = handlerLabel
+			accessEntry.Upstream = requestInfo.Upstream()
+			accessEntry.Status = recorder.Status()
+			accessEntry.Bytes = recorder.Bytes()
+			accessEntry.Duration = duration
+			accessLogger.Log(accessEntry)
 		}
-	} else {
-		host = r.Host
-		port = cfg.Global.Port
+	}()
+
+	hostname, port, err := matcher.SplitRequestHost(r.Host, listenerPort)
+	if err != nil {
+		logger.Error("Failed to parse host and port", "host", r.Host, "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
 	}
 
-	listenerController, ok := listenerControllers[port]
+	listenerController, ok := getListenerController(port)
 	if !ok {
 		logger.Warn("No listener controller for port", "port", port)
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
 
-	handlers, ok := listenerController.TargetHandler[host]
+	// Metrics, access logs and handler IDs use the host pattern that served the
+	// request, so that wildcard hosts do not create a label per subdomain.
+	host, ok := listenerController.Hosts.Resolve(hostname)
 	if !ok {
-		logger.Warn("No handlers for host", "host", host)
+		logger.Warn("No handlers for host", "host", hostname)
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
+	handlers := listenerController.TargetHandler[host]
+
+	hostLabel = host
+	accessLogger = listenerController.HostAccessLogs[host]
+
+	handler := listenerController.Routers[host].Match(r)
+	if handler != nil {
+		handlerLabel = handlerID(host, port, slices.Index(handlers, handler), handler)
+		accessLogger = listenerController.HandlerAccessLogs[handler]
+	}
+
+	if accessLogger != nil {
+		accessEntry = accesslog.NewEntry(r, start, requestID)
+	}
 
-	handler := matcher.MatchHandler(r, handlers)
 	if handler != nil {
 		handleRequest(w, r, handler)
 	} else {
@@ -211,6 +778,17 @@ func defaultHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// localPort returns the port the request arrived on, which is what a Host header
+// without an explicit port refers to (e.g. 443 for TLS listeners).
+func localPort(r *http.Request) int {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+			return tcpAddr.Port
+		}
+	}
+	return config.GetConfig().Global.Port
+}
+
 func handleRequest(w http.ResponseWriter, r *http.Request, handler *config.HandlerConfig) {
 	logger := utils.GetLogger()
 
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+)
+
+func TestBuildListenerControllersHandlerNames(t *testing.T) {
+	utils.GetLogger()
+
+	tests := []struct {
+		name      string
+		listeners []config.ListenerConfig
+		wantErr   bool
+	}{
+		{
+			name: "unique names",
+			listeners: []config.ListenerConfig{
+				{Host: []string{"a.example.com:8080"}, Handlers: []config.HandlerConfig{{Name: "api"}, {Name: "web"}}},
+			},
+		},
+		{
+			name: "unnamed handlers",
+			listeners: []config.ListenerConfig{
+				{Host: []string{"a.example.com:8080"}, Handlers: []config.HandlerConfig{{}, {}}},
+				{Host: []string{"b.example.com:8080"}, Handlers: []config.HandlerConfig{{}}},
+			},
+		},
+		{
+			name: "handler shared by several hosts",
+			listeners: []config.ListenerConfig{
+				{Host: []string{"a.example.com:8080", "b.example.com:8081"}, Handlers: []config.HandlerConfig{{Name: "api"}}},
+			},
+		},
+		{
+			name: "duplicate name in a listener",
+			listeners: []config.ListenerConfig{
+				{Host: []string{"a.example.com:8080"}, Handlers: []config.HandlerConfig{{Name: "api"}, {Name: "api"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate name across listeners",
+			listeners: []config.ListenerConfig{
+				{Host: []string{"a.example.com:8080"}, Handlers: []config.HandlerConfig{{Name: "api"}}},
+				{Host: []string{"b.example.com:8081"}, Handlers: []config.HandlerConfig{{Name: "api"}}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := buildListenerControllers(&config.Config{Listeners: tt.listeners})
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), `"api"`) {
+					t.Fatalf("expected a duplicate name error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildListenerControllers: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/services/matcher"
+)
+
+// serverTimeouts are the resolved timeouts of a server. They are comparable, so that a
+// reload can tell whether the server of a port has to be restarted.
+type serverTimeouts struct {
+	readHeader time.Duration
+	read       time.Duration
+	write      time.Duration
+	idle       time.Duration
+}
+
+func newServerTimeouts(timeoutsConfig *config.ServerTimeoutsConfig) serverTimeouts {
+	timeouts := serverTimeouts{
+		readHeader: 10 * time.Second,
+		idle:       120 * time.Second,
+	}
+	if timeoutsConfig == nil {
+		return timeouts
+	}
+
+	if timeoutsConfig.ReadHeader > 0 {
+		timeouts.readHeader = time.Duration(timeoutsConfig.ReadHeader) * time.Second
+	}
+	if timeoutsConfig.Idle > 0 {
+		timeouts.idle = time.Duration(timeoutsConfig.Idle) * time.Second
+	}
+	timeouts.read = time.Duration(timeoutsConfig.Read) * time.Second
+	timeouts.write = time.Duration(timeoutsConfig.Write) * time.Second
+
+	return timeouts
+}
+
+func (t serverTimeouts) apply(server *http.Server) {
+	server.ReadHeaderTimeout = t.readHeader
+	server.ReadTimeout = t.read
+	server.WriteTimeout = t.write
+	server.IdleTimeout = t.idle
+}
+
+// loadServerTimeouts sets the timeouts of every port from the first listener block of
+// the port that declares them.
+func loadServerTimeouts(cfg *config.Config, controllers map[int]ListenerController) error {
+	declared := map[int]bool{}
+
+	for _, listenerConfig := range cfg.Listeners {
+		if listenerConfig.Timeouts == nil {
+			continue
+		}
+
+		for _, host := range listenerConfig.Host {
+			_, port, err := matcher.SplitListenerHost(host)
+			if err != nil {
+				return err
+			}
+
+			if declared[port] {
+				continue
+			}
+			declared[port] = true
+
+			listenerController := controllers[port]
+			listenerController.Timeouts = newServerTimeouts(listenerConfig.Timeouts)
+			controllers[port] = listenerController
+		}
+	}
+
+	return nil
+}
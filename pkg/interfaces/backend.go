@@ -1,6 +1,7 @@
 package interfaces
 
 import (
+	"context"
 	"net/http"
 	"net/url"
 )
@@ -10,8 +11,28 @@ type Backend interface {
 
 	IsAlive() bool
 
+	SetDisabled(bool)
+
+	IsDisabled() bool
+
+	SetDraining(bool)
+
+	IsDraining() bool
+
+	SetEjected(bool)
+
+	IsEjected() bool
+
+	IsAvailable() bool
+
+	RecordResult(ctx context.Context, success bool)
+
+	GetCircuitState() string
+
 	GetURL() *url.URL
 
+	GetWeight() int
+
 	GetActiveConnections() int
 
 	Serve(http.ResponseWriter, *http.Request)
@@ -6,4 +6,6 @@ import (
 
 type DNSResolver interface {
 	GetDynamicUpstreams(dynamicUpstreams []config.DynamicUpstreamConfig) ([]string, error)
+
+	ClearCache()
 }
@@ -7,5 +7,7 @@ import (
 )
 
 type Matcher interface {
-	MatchHandler(r *http.Request, handlers []*config.HandlerConfig) *config.HandlerConfig
+	Match(r *http.Request) *config.HandlerConfig
+
+	Handlers() []*config.HandlerConfig
 }
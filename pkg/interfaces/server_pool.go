@@ -11,5 +11,7 @@ type ServerPool interface {
 
 	AddBackend(Backend)
 
+	RemoveBackend(Backend)
+
 	GetServerPoolSize() int
 }
@@ -13,14 +13,17 @@ var (
 	mu            sync.Mutex
 )
 
-func NewLogger(cfg config.Config) interfaces.Logger {
-	logger := NewZapLogger(cfg)
+func NewLogger(cfg config.Config) (interfaces.Logger, error) {
+	logger, err := NewZapLogger(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	mu.Lock()
 	defer mu.Unlock()
 	defaultLogger = logger
 
-	return logger
+	return logger, nil
 }
 
 func GetLogger() interfaces.Logger {
@@ -0,0 +1,71 @@
+package logger
+
+import (
+	"os"
+	"sync"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+type fileOutput struct {
+	rotation config.RotationConfig
+	writer   zapcore.WriteSyncer
+	file     *lumberjack.Logger
+}
+
+var (
+	fileOutputs      = map[string]*fileOutput{}
+	fileOutputsMutex sync.Mutex
+)
+
+// OpenOutput returns a writer for "stdout", "stderr" or a file path. Files are rotated
+// by size and shared between every logger writing to the same path, so that a file is
+// only rotated once and reloads keep appending to the open file.
+func OpenOutput(output string, rotation config.RotationConfig) (zapcore.WriteSyncer, error) {
+	switch output {
+	case "", "stdout":
+		return zapcore.Lock(os.Stdout), nil
+	case "stderr":
+		return zapcore.Lock(os.Stderr), nil
+	}
+
+	if rotation.MaxSize == 0 {
+		rotation.MaxSize = 100
+	}
+
+	fileOutputsMutex.Lock()
+	defer fileOutputsMutex.Unlock()
+
+	existing, ok := fileOutputs[output]
+	if ok && existing.rotation == rotation {
+		return existing.writer, nil
+	}
+
+	file := &lumberjack.Logger{
+		Filename:   output,
+		MaxSize:    rotation.MaxSize,
+		MaxBackups: rotation.MaxBackups,
+		MaxAge:     rotation.MaxAge,
+		Compress:   rotation.Compress,
+	}
+
+	// Open the file right away so that a bad path fails the configuration instead of
+	// every later write.
+	if _, err := file.Write(nil); err != nil {
+		return nil, err
+	}
+
+	if ok {
+		_ = existing.file.Close()
+	}
+
+	fileOutputs[output] = &fileOutput{
+		rotation: rotation,
+		writer:   zapcore.AddSync(file),
+		file:     file,
+	}
+
+	return fileOutputs[output].writer, nil
+}
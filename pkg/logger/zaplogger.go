@@ -1,7 +1,8 @@
 package logger
 
 import (
-	"os"
+	"fmt"
+	"time"
 
 	"github.com/letronghoangminh/reproxy/pkg/config"
 	"github.com/letronghoangminh/reproxy/pkg/interfaces"
@@ -9,6 +10,8 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
+var level = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+
 type ZapLogger struct {
 	logger *zap.Logger
 }
@@ -43,37 +46,120 @@ func (l *ZapLogger) Sync() error {
 	return l.logger.Sync()
 }
 
-func NewZapLogger(cfg config.Config) interfaces.Logger {
-	var level zapcore.Level
-	switch cfg.Global.LogLevel {
+func NewZapLogger(cfg config.Config) (interfaces.Logger, error) {
+	if err := SetLevel(cfg.Global.LogLevel); err != nil {
+		return nil, err
+	}
+
+	logConfig := config.LogConfig{}
+	if cfg.Global.Log != nil {
+		logConfig = *cfg.Global.Log
+	}
+
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+
+	var encoder zapcore.Encoder
+	switch logConfig.Format {
+	case "", "json":
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	case "console":
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected json or console", logConfig.Format)
+	}
+
+	outputs := logConfig.Outputs
+	if len(outputs) == 0 {
+		outputs = []config.LogOutputConfig{{Path: "stdout"}}
+	}
+
+	writers := make([]zapcore.WriteSyncer, 0, len(outputs))
+	for _, output := range outputs {
+		writer, err := OpenOutput(output.Path, output.Rotation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log output %q: %w", output.Path, err)
+		}
+		writers = append(writers, writer)
+	}
+	writer := zapcore.NewMultiWriteSyncer(writers...)
+
+	core := zapcore.NewCore(encoder, writer, level)
+
+	// Sampling only applies to debug logs, which are emitted for every request, so that
+	// warnings and errors are never dropped.
+	if logConfig.Sampling != nil {
+		initial, thereafter := logConfig.Sampling.Initial, logConfig.Sampling.Thereafter
+		if initial == 0 {
+			initial = 100
+		}
+		if thereafter == 0 {
+			thereafter = 100
+		}
+
+		debugCore := zapcore.NewCore(encoder, writer, zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+			return l == zapcore.DebugLevel && level.Enabled(l)
+		}))
+		otherCore := zapcore.NewCore(encoder, writer, zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+			return l > zapcore.DebugLevel && level.Enabled(l)
+		}))
+
+		core = zapcore.NewTee(
+			zapcore.NewSamplerWithOptions(debugCore, time.Second, initial, thereafter),
+			otherCore,
+		)
+	}
+
+	logger := zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1), zap.AddStacktrace(zapcore.ErrorLevel))
+
+	return &ZapLogger{
+		logger: logger,
+	}, nil
+}
+
+// SetLevel changes the level of every logger created by NewZapLogger, including the
+// ones derived through With.
+func SetLevel(name string) error {
+	var l zapcore.Level
+	switch name {
 	case "debug":
-		level = zapcore.DebugLevel
+		l = zapcore.DebugLevel
 	case "info":
-		level = zapcore.InfoLevel
+		l = zapcore.InfoLevel
 	case "warn":
-		level = zapcore.WarnLevel
+		l = zapcore.WarnLevel
 	case "error":
-		level = zapcore.ErrorLevel
+		l = zapcore.ErrorLevel
 	case "fatal":
-		level = zapcore.FatalLevel
+		l = zapcore.FatalLevel
 	default:
-		level = zapcore.InfoLevel
+		return fmt.Errorf("unknown log level %q, expected debug, info, warn, error or fatal", name)
 	}
 
-	encoderConfig := zap.NewProductionEncoderConfig()
-	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-	encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+	level.SetLevel(l)
+	return nil
+}
 
-	core := zapcore.NewCore(
-		zapcore.NewJSONEncoder(encoderConfig),
-		zapcore.NewMultiWriteSyncer(zapcore.AddSync(os.Stdout)),
-		level,
-	)
+func GetLevel() string {
+	return level.Level().String()
+}
 
-	logger := zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1), zap.AddStacktrace(zapcore.ErrorLevel))
+// NewRecordLogger returns a JSON logger without level, caller or stacktrace fields,
+// for records such as access log entries that are written on every request.
+func NewRecordLogger(writer zapcore.WriteSyncer) interfaces.Logger {
+	encoderConfig := zapcore.EncoderConfig{
+		TimeKey:        "time",
+		MessageKey:     "msg",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeTime:     zapcore.ISO8601TimeEncoder,
+		EncodeDuration: zapcore.MillisDurationEncoder,
+	}
+
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), writer, zapcore.InfoLevel)
 
 	return &ZapLogger{
-		logger: logger,
+		logger: zap.New(core),
 	}
 }
 
@@ -0,0 +1,184 @@
+// Package accesslog writes one record per completed request in the Common Log Format,
+// the Combined Log Format or as JSON.
+package accesslog
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/interfaces"
+	"github.com/letronghoangminh/reproxy/pkg/logger"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	FormatCommon   = "common"
+	FormatCombined = "combined"
+	FormatJSON     = "json"
+
+	clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+)
+
+// Placeholders lists the fields available to JSON access logs. The request
+// placeholders are the ones also accepted in header values.
+var Placeholders = []string{
+	"{remote_ip}", "{scheme}", "{host}", "{path}", "{query}", "{method}", "{user_agent}",
+	"{proto}", "{referer}", "{request_id}", "{port}", "{handler}", "{upstream}",
+	"{status}", "{bytes}", "{duration_ms}",
+}
+
+var clfEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// Entry describes one request. The request fields are captured before the request is
+// handled, since handlers such as the reverse proxy rewrite the path.
+type Entry struct {
+	Start      time.Time
+	RequestID  string
+	RequestURI string
+	Proto      string
+	Referer    string
+	Port       int
+	Handler    string
+	Upstream   string
+	Status     int
+	Bytes      int64
+	Duration   time.Duration
+
+	placeholders map[string]string
+}
+
+func NewEntry(r *http.Request, start time.Time, requestID string) *Entry {
+	return &Entry{
+		Start:        start,
+		RequestID:    requestID,
+		RequestURI:   r.RequestURI,
+		Proto:        r.Proto,
+		Referer:      r.Referer(),
+		placeholders: utils.RequestPlaceholders(r),
+	}
+}
+
+func (e *Entry) value(placeholder string) any {
+	switch placeholder {
+	case "{proto}":
+		return e.Proto
+	case "{referer}":
+		return e.Referer
+	case "{request_id}":
+		return e.RequestID
+	case "{port}":
+		return e.Port
+	case "{handler}":
+		return e.Handler
+	case "{upstream}":
+		return e.Upstream
+	case "{status}":
+		return e.Status
+	case "{bytes}":
+		return e.Bytes
+	case "{duration_ms}":
+		return float64(e.Duration.Microseconds()) / 1000
+	default:
+		return e.placeholders[placeholder]
+	}
+}
+
+type AccessLogger struct {
+	format string
+	fields []string
+	writer zapcore.WriteSyncer
+	logger interfaces.Logger
+}
+
+func NewAccessLogger(accessLogConfig *config.AccessLogConfig) (*AccessLogger, error) {
+	format := accessLogConfig.Format
+	if format == "" {
+		format = FormatCombined
+	}
+
+	fields := accessLogConfig.Fields
+	if len(fields) == 0 {
+		fields = Placeholders
+	}
+	for _, field := range fields {
+		if !slices.Contains(Placeholders, field) {
+			return nil, fmt.Errorf("unknown access log field %q", field)
+		}
+	}
+
+	writer, err := logger.OpenOutput(accessLogConfig.Output, accessLogConfig.Rotation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access log output %q: %w", accessLogConfig.Output, err)
+	}
+
+	accessLogger := &AccessLogger{
+		format: format,
+		fields: fields,
+		writer: writer,
+	}
+	if format == FormatJSON {
+		accessLogger.logger = logger.NewRecordLogger(writer)
+	}
+
+	return accessLogger, nil
+}
+
+func (l *AccessLogger) Log(e *Entry) {
+	if l.format == FormatJSON {
+		fields := make([]interface{}, 0, len(l.fields)*2)
+		for _, field := range l.fields {
+			fields = append(fields, strings.Trim(field, "{}"), e.value(field))
+		}
+		l.logger.Info("request", fields...)
+		return
+	}
+
+	if _, err := l.writer.Write([]byte(l.formatCLF(e))); err != nil {
+		utils.GetLogger().Error("failed to write access log", "error", err)
+	}
+}
+
+// formatCLF renders the entry in the Common Log Format, followed by the referer and
+// user agent for the Combined Log Format.
+func (l *AccessLogger) formatCLF(e *Entry) string {
+	var b strings.Builder
+
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = strconv.FormatInt(e.Bytes, 10)
+	}
+
+	fmt.Fprintf(&b, `%s - - [%s] "%s %s %s" %d %s`,
+		e.placeholders["{remote_ip}"],
+		e.Start.Format(clfTimeFormat),
+		clfValue(e.placeholders["{method}"]),
+		clfValue(e.RequestURI),
+		clfValue(e.Proto),
+		e.Status,
+		bytes,
+	)
+
+	if l.format == FormatCombined {
+		fmt.Fprintf(&b, ` "%s" "%s"`,
+			clfValue(e.Referer),
+			clfValue(e.placeholders["{user_agent}"]),
+		)
+	}
+
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// clfValue escapes quotes and uses "-" for empty values, as web servers do.
+func clfValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return clfEscaper.Replace(value)
+}
@@ -0,0 +1,107 @@
+//go:build integration
+
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+)
+
+// TestACMEIssuanceWithPebble issues a certificate from a running Pebble server
+// (https://github.com/letsencrypt/pebble) through an HTTP-01 challenge:
+//
+//	pebble -config test/config/pebble-config.json
+//	REPROXY_PEBBLE_DIRECTORY=https://localhost:14000/dir \
+//	REPROXY_PEBBLE_CA_ROOT=test/certs/pebble.minica.pem \
+//	go test -tags integration ./pkg/services/certs/
+//
+// Pebble validates the challenge on REPROXY_PEBBLE_HTTP_PORT (default: 5002, the
+// httpPort of the Pebble configuration) of REPROXY_PEBBLE_DOMAIN (default: localhost).
+func TestACMEIssuanceWithPebble(t *testing.T) {
+	directoryURL := os.Getenv("REPROXY_PEBBLE_DIRECTORY")
+	if directoryURL == "" {
+		t.Skip("REPROXY_PEBBLE_DIRECTORY is not set")
+	}
+	domain := envOrDefault("REPROXY_PEBBLE_DOMAIN", "localhost")
+	httpPort := envOrDefault("REPROXY_PEBBLE_HTTP_PORT", "5002")
+
+	acmeConfig := &config.ACMEConfig{
+		DirectoryURL: directoryURL,
+		CARoot:       os.Getenv("REPROXY_PEBBLE_CA_ROOT"),
+		Storage:      t.TempDir(),
+		Email:        "admin@example.com",
+	}
+	manager, err := NewACMEManager(acmeConfig, nil)
+	if err != nil {
+		t.Fatalf("NewACMEManager: %v", err)
+	}
+	manager.AddHosts(domain)
+
+	listener, err := net.Listen("tcp", ":"+httpPort)
+	if err != nil {
+		t.Fatalf("failed to listen for HTTP-01 challenges: %v", err)
+	}
+	server := &http.Server{Handler: manager.HTTPHandler(http.NotFoundHandler())}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("challenge server: %v", err)
+		}
+	}()
+	t.Cleanup(func() { _ = server.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	if err := manager.register(ctx); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	// Registering an existing account is not an error.
+	if err := manager.register(ctx); err != nil {
+		t.Fatalf("register again: %v", err)
+	}
+
+	if err := manager.obtain(ctx, domain); err != nil {
+		t.Fatalf("obtain: %v", err)
+	}
+
+	certificate, err := manager.GetCertificate(&tls.ClientHelloInfo{ServerName: domain})
+	if err != nil {
+		t.Fatalf("GetCertificate: %v", err)
+	}
+	if !slices.Contains(certificate.Leaf.DNSNames, domain) {
+		t.Errorf("certificate names %v do not include %s", certificate.Leaf.DNSNames, domain)
+	}
+	if len(manager.tokens) != 0 {
+		t.Errorf("challenge tokens were not cleaned up: %v", manager.tokens)
+	}
+
+	// A restarted instance serves the stored certificate without a new order.
+	restarted, err := NewACMEManager(acmeConfig, nil)
+	if err != nil {
+		t.Fatalf("NewACMEManager: %v", err)
+	}
+	restarted.AddHosts(domain)
+	restarted.LoadStoredCertificates()
+	if restarted.needsRenewal(domain) {
+		t.Error("stored certificate should not need renewal")
+	}
+	if err := restarted.register(ctx); err != nil {
+		t.Fatalf("register with the stored account key: %v", err)
+	}
+}
+
+func envOrDefault(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
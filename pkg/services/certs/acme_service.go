@@ -0,0 +1,473 @@
+package certs
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/interfaces"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	ACMEChallengePrefix = "/.well-known/acme-challenge/"
+	acmeCheckInterval   = time.Hour
+	acmeOrderTimeout    = 5 * time.Minute
+)
+
+// ACMEManager obtains and renews certificates through the ACME protocol using
+// HTTP-01 challenges, and keeps account keys and certificates in a storage directory.
+type ACMEManager struct {
+	client       *acme.Client
+	email        string
+	storage      string
+	renewBefore  time.Duration
+	hosts        map[string]struct{}
+	certificates map[string]*tls.Certificate
+	tokens       map[string]string
+	mutex        sync.RWMutex
+	logger       interfaces.Logger
+}
+
+func NewACMEManager(acmeConfig *config.ACMEConfig, logger interfaces.Logger) (*ACMEManager, error) {
+	if logger == nil {
+		logger = utils.GetLogger()
+	}
+	if acmeConfig == nil {
+		acmeConfig = &config.ACMEConfig{}
+	}
+
+	directoryURL := acmeConfig.DirectoryURL
+	if directoryURL == "" {
+		directoryURL = acme.LetsEncryptURL
+	}
+
+	storage := acmeConfig.Storage
+	if storage == "" {
+		storage = "certificates"
+	}
+
+	renewBefore := acmeConfig.RenewBefore
+	if renewBefore == 0 {
+		renewBefore = 30
+	}
+
+	if err := os.MkdirAll(storage, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create ACME storage %q: %w", storage, err)
+	}
+
+	accountKey, err := loadOrCreateKey(filepath.Join(storage, "account.key"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ACME account key: %w", err)
+	}
+
+	httpClient, err := newACMEHTTPClient(acmeConfig.CARoot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ACMEManager{
+		client: &acme.Client{
+			Key:          accountKey,
+			DirectoryURL: directoryURL,
+			HTTPClient:   httpClient,
+			UserAgent:    "reproxy",
+		},
+		email:        acmeConfig.Email,
+		storage:      storage,
+		renewBefore:  time.Duration(renewBefore) * 24 * time.Hour,
+		hosts:        make(map[string]struct{}),
+		certificates: make(map[string]*tls.Certificate),
+		tokens:       make(map[string]string),
+		logger:       logger,
+	}, nil
+}
+
+// AddHosts registers hostnames the manager should keep certificates for. IP
+// addresses and wildcards are skipped since HTTP-01 cannot validate them.
+func (m *ACMEManager) AddHosts(hostnames ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, hostname := range hostnames {
+		hostname = normalizeServerName(hostname)
+		if net.ParseIP(hostname) != nil || strings.Contains(hostname, "*") {
+			m.logger.Warn("Skipping ACME certificate for unsupported host", "host", hostname)
+			continue
+		}
+		m.hosts[hostname] = struct{}{}
+	}
+}
+
+// GetCertificate returns the issued certificate for the SNI server name of the handshake.
+func (m *ACMEManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	serverName := normalizeServerName(hello.ServerName)
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	certificate, ok := m.certificates[serverName]
+	if !ok {
+		return nil, fmt.Errorf("certificate for %q has not been issued yet", serverName)
+	}
+
+	return certificate, nil
+}
+
+// HTTPHandler answers HTTP-01 challenges and passes every other request to next.
+func (m *ACMEManager) HTTPHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, ACMEChallengePrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := strings.TrimPrefix(r.URL.Path, ACMEChallengePrefix)
+
+		m.mutex.RLock()
+		keyAuth, ok := m.tokens[token]
+		m.mutex.RUnlock()
+
+		if !ok {
+			m.logger.Debug("Unknown ACME challenge token", "token", token)
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(keyAuth))
+	})
+}
+
+// Run registers the ACME account, issues missing certificates and renews them
+// in the background until the context is cancelled. Stored certificates are loaded
+// beforehand with LoadStoredCertificates.
+func (m *ACMEManager) Run(ctx context.Context) {
+	if err := m.register(ctx); err != nil {
+		m.logger.Error("ACME account registration failed", "error", err)
+	}
+
+	m.renewCertificates(ctx)
+
+	t := time.NewTicker(acmeCheckInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			m.renewCertificates(ctx)
+		case <-ctx.Done():
+			m.logger.Info("Stopping ACME certificate renewal")
+			return
+		}
+	}
+}
+
+func (m *ACMEManager) register(ctx context.Context) error {
+	account := &acme.Account{}
+	if m.email != "" {
+		account.Contact = []string{"mailto:" + m.email}
+	}
+
+	_, err := m.client.Register(ctx, account, acme.AcceptTOS)
+	if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return err
+	}
+
+	return nil
+}
+
+// LoadStoredCertificates loads the certificates of the hosts found in the storage, so
+// that they are served as soon as the manager is.
+func (m *ACMEManager) LoadStoredCertificates() {
+	for _, hostname := range m.hostnames() {
+		certificate, err := tls.LoadX509KeyPair(m.certificatePath(hostname), m.keyPath(hostname))
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				m.logger.Warn("Failed to load stored ACME certificate", "host", hostname, "error", err)
+			}
+			continue
+		}
+
+		certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			m.logger.Warn("Failed to parse stored ACME certificate", "host", hostname, "error", err)
+			continue
+		}
+
+		m.mutex.Lock()
+		m.certificates[hostname] = &certificate
+		m.mutex.Unlock()
+
+		m.logger.Info("Loaded stored ACME certificate", "host", hostname, "not_after", certificate.Leaf.NotAfter)
+	}
+}
+
+func (m *ACMEManager) renewCertificates(ctx context.Context) {
+	for _, hostname := range m.hostnames() {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if !m.needsRenewal(hostname) {
+			continue
+		}
+
+		m.logger.Info("Obtaining ACME certificate", "host", hostname)
+		if err := m.obtain(ctx, hostname); err != nil {
+			m.logger.Error("Failed to obtain ACME certificate", "host", hostname, "error", err)
+			continue
+		}
+		m.logger.Info("ACME certificate issued", "host", hostname)
+	}
+}
+
+func (m *ACMEManager) needsRenewal(hostname string) bool {
+	m.mutex.RLock()
+	certificate, ok := m.certificates[hostname]
+	m.mutex.RUnlock()
+
+	if !ok || certificate.Leaf == nil {
+		return true
+	}
+
+	return time.Now().Add(m.renewBefore).After(certificate.Leaf.NotAfter)
+}
+
+func (m *ACMEManager) obtain(ctx context.Context, hostname string) error {
+	ctx, cancel := context.WithTimeout(ctx, acmeOrderTimeout)
+	defer cancel()
+
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(hostname))
+	if err != nil {
+		return fmt.Errorf("failed to create order: %w", err)
+	}
+
+	orderURL := order.URI
+	for _, authzURL := range order.AuthzURLs {
+		if err := m.authorize(ctx, authzURL); err != nil {
+			return err
+		}
+	}
+
+	order, err = m.client.WaitOrder(ctx, orderURL)
+	if err != nil {
+		return fmt.Errorf("order was not ready: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: hostname},
+		DNSNames: []string{hostname},
+	}, key)
+	if err != nil {
+		return err
+	}
+
+	chain, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		chain, err = m.fetchFinalizedCert(ctx, orderURL, err)
+		if err != nil {
+			return err
+		}
+	}
+
+	return m.storeCertificate(hostname, chain, key)
+}
+
+// fetchFinalizedCert waits for an order whose finalization failed on the client side.
+// CAs issuing asynchronously, such as Pebble, may answer the finalize request without
+// the order URL the client needs to wait for the certificate.
+func (m *ACMEManager) fetchFinalizedCert(ctx context.Context, orderURL string, finalizeErr error) ([][]byte, error) {
+	order, err := m.client.GetOrder(ctx, orderURL)
+	if err != nil || (order.Status != acme.StatusProcessing && order.Status != acme.StatusValid) {
+		return nil, fmt.Errorf("failed to finalize order: %w", finalizeErr)
+	}
+
+	order, err = m.client.WaitOrder(ctx, orderURL)
+	if err != nil {
+		return nil, fmt.Errorf("order was not issued: %w", err)
+	}
+	if order.Status != acme.StatusValid {
+		return nil, fmt.Errorf("order was not issued, status %s", order.Status)
+	}
+
+	return m.client.FetchCert(ctx, order.CertURL, true)
+}
+
+func (m *ACMEManager) authorize(ctx context.Context, authzURL string) error {
+	authz, err := m.client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch authorization: %w", err)
+	}
+
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "http-01" {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("no http-01 challenge offered for %q", authz.Identifier.Value)
+	}
+
+	keyAuth, err := m.client.HTTP01ChallengeResponse(challenge.Token)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	m.tokens[challenge.Token] = keyAuth
+	m.mutex.Unlock()
+
+	defer func() {
+		m.mutex.Lock()
+		delete(m.tokens, challenge.Token)
+		m.mutex.Unlock()
+	}()
+
+	if _, err := m.client.Accept(ctx, challenge); err != nil {
+		return fmt.Errorf("failed to accept challenge: %w", err)
+	}
+
+	if _, err := m.client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("authorization failed: %w", err)
+	}
+
+	return nil
+}
+
+func (m *ACMEManager) storeCertificate(hostname string, chain [][]byte, key *ecdsa.PrivateKey) error {
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(m.keyPath(hostname), keyPEM, 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.certificatePath(hostname), certPEM, 0o600); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	m.certificates[hostname] = &certificate
+	m.mutex.Unlock()
+
+	return nil
+}
+
+func (m *ACMEManager) hostnames() []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	hostnames := make([]string, 0, len(m.hosts))
+	for hostname := range m.hosts {
+		hostnames = append(hostnames, hostname)
+	}
+
+	return hostnames
+}
+
+func (m *ACMEManager) certificatePath(hostname string) string {
+	return filepath.Join(m.storage, hostname+".crt")
+}
+
+func (m *ACMEManager) keyPath(hostname string) string {
+	return filepath.Join(m.storage, hostname+".key")
+}
+
+func loadOrCreateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM data found in %q", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func newACMEHTTPClient(caRoot string) (*http.Client, error) {
+	if caRoot == "" {
+		return http.DefaultClient, nil
+	}
+
+	pemData, err := os.ReadFile(caRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ACME CA root %q: %w", caRoot, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("no certificates found in ACME CA root %q", caRoot)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    pool,
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,163 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+)
+
+func newTestACMEManager(t *testing.T, storage string, renewBefore int) *ACMEManager {
+	t.Helper()
+
+	manager, err := NewACMEManager(&config.ACMEConfig{
+		DirectoryURL: "https://acme.invalid/directory",
+		Storage:      storage,
+		RenewBefore:  renewBefore,
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewACMEManager: %v", err)
+	}
+	return manager
+}
+
+// selfSignedChain returns a certificate for hostname expiring at notAfter, as it would
+// be returned by the CA.
+func selfSignedChain(t *testing.T, hostname string, notAfter time.Time) ([][]byte, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: hostname},
+		DNSNames:     []string{hostname},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return [][]byte{der}, key
+}
+
+func TestLoadStoredCertificates(t *testing.T) {
+	storage := t.TempDir()
+
+	issuer := newTestACMEManager(t, storage, 30)
+	chain, key := selfSignedChain(t, "example.com", time.Now().Add(90*24*time.Hour))
+	if err := issuer.storeCertificate("example.com", chain, key); err != nil {
+		t.Fatalf("storeCertificate: %v", err)
+	}
+
+	// A restarted instance reuses the account key and the stored certificate.
+	manager := newTestACMEManager(t, storage, 30)
+	if !manager.client.Key.Public().(*ecdsa.PublicKey).Equal(issuer.client.Key.Public()) {
+		t.Error("account key was not reused from the storage")
+	}
+
+	manager.AddHosts("Example.com.", "missing.example.com", "10.0.0.1", "*.example.com")
+	manager.LoadStoredCertificates()
+
+	certificate, err := manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if err != nil {
+		t.Fatalf("GetCertificate: %v", err)
+	}
+	if string(certificate.Certificate[0]) != string(chain[0]) {
+		t.Error("loaded certificate differs from the stored one")
+	}
+	if manager.needsRenewal("example.com") {
+		t.Error("stored certificate should not need renewal")
+	}
+
+	if _, err := manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "missing.example.com"}); err == nil {
+		t.Error("expected no certificate for a host without stored files")
+	}
+	if hostnames := manager.hostnames(); len(hostnames) != 2 {
+		t.Errorf("expected IP and wildcard hosts to be skipped, got %v", hostnames)
+	}
+}
+
+func TestLoadStoredCertificatesSkipsCorruptFiles(t *testing.T) {
+	storage := t.TempDir()
+	manager := newTestACMEManager(t, storage, 30)
+
+	chain, key := selfSignedChain(t, "example.com", time.Now().Add(90*24*time.Hour))
+	if err := manager.storeCertificate("example.com", chain, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(storage, "example.com.crt"), []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newTestACMEManager(t, storage, 30)
+	restarted.AddHosts("example.com")
+	restarted.LoadStoredCertificates()
+
+	if _, err := restarted.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"}); err == nil {
+		t.Error("expected a corrupt certificate not to be loaded")
+	}
+	if !restarted.needsRenewal("example.com") {
+		t.Error("expected a corrupt certificate to be issued again")
+	}
+}
+
+func TestLoadOrCreateKeyRejectsInvalidPEM(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "account.key")
+	if err := os.WriteFile(path, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadOrCreateKey(path); err == nil {
+		t.Error("expected an error for a key file without PEM data")
+	}
+}
+
+func TestNeedsRenewal(t *testing.T) {
+	tests := []struct {
+		name        string
+		renewBefore int
+		expiresIn   time.Duration
+		want        bool
+	}{
+		{name: "far from expiry", renewBefore: 30, expiresIn: 60 * 24 * time.Hour, want: false},
+		{name: "just outside the threshold", renewBefore: 30, expiresIn: 31 * 24 * time.Hour, want: false},
+		{name: "just inside the threshold", renewBefore: 30, expiresIn: 29 * 24 * time.Hour, want: true},
+		{name: "expired", renewBefore: 30, expiresIn: -time.Hour, want: true},
+		{name: "custom threshold", renewBefore: 7, expiresIn: 10 * 24 * time.Hour, want: false},
+		{name: "default threshold", renewBefore: 0, expiresIn: 20 * 24 * time.Hour, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newTestACMEManager(t, t.TempDir(), tt.renewBefore)
+			manager.AddHosts("example.com")
+
+			chain, key := selfSignedChain(t, "example.com", time.Now().Add(tt.expiresIn))
+			if err := manager.storeCertificate("example.com", chain, key); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := manager.needsRenewal("example.com"); got != tt.want {
+				t.Errorf("needsRenewal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	manager := newTestACMEManager(t, t.TempDir(), 30)
+	if !manager.needsRenewal("unissued.example.com") {
+		t.Error("expected a host without certificate to need one")
+	}
+}
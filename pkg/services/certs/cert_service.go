@@ -0,0 +1,208 @@
+// Package certs provides functionality to load TLS certificates and select them by SNI.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/interfaces"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+type hostEntry struct {
+	config *tls.Config
+}
+
+// CertificateStore holds the certificates of every host sharing a listener port and
+// picks the right one for each TLS handshake based on the SNI server name.
+type CertificateStore struct {
+	hosts    map[string]*hostEntry
+	fallback *hostEntry
+	mutex    sync.RWMutex
+	logger   interfaces.Logger
+}
+
+func NewCertificateStore(logger interfaces.Logger) *CertificateStore {
+	if logger == nil {
+		logger = utils.GetLogger()
+	}
+
+	return &CertificateStore{
+		hosts:  make(map[string]*hostEntry),
+		logger: logger,
+	}
+}
+
+// AddListener loads the certificates of a listener block. Certificates without an
+// explicit hosts list are used for every hostname the listener is bound to, and
+// hostnames without a static certificate are served by the ACME manager when enabled.
+func (s *CertificateStore) AddListener(hostnames []string, tlsConfig *config.TLSConfig, acmeManager *ACMEManager) error {
+	baseConfig, err := newBaseConfig(tlsConfig)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if tlsConfig.ACME {
+		if acmeManager == nil {
+			return errors.New("ACME is enabled but no ACME manager is configured")
+		}
+
+		entryConfig := baseConfig.Clone()
+		entryConfig.GetCertificate = acmeManager.GetCertificate
+		entry := &hostEntry{
+			config: entryConfig,
+		}
+
+		for _, host := range hostnames {
+			s.hosts[normalizeServerName(host)] = entry
+		}
+		acmeManager.AddHosts(hostnames...)
+
+		if s.fallback == nil {
+			s.fallback = entry
+		}
+	}
+
+	for _, certConfig := range tlsConfig.Certificates {
+		certificate, err := tls.LoadX509KeyPair(certConfig.CertFile, certConfig.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate %q: %w", certConfig.CertFile, err)
+		}
+
+		if certificate.Leaf == nil {
+			certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+			if err != nil {
+				return fmt.Errorf("failed to parse certificate %q: %w", certConfig.CertFile, err)
+			}
+		}
+
+		entryConfig := baseConfig.Clone()
+		entryConfig.Certificates = []tls.Certificate{certificate}
+		entry := &hostEntry{
+			config: entryConfig,
+		}
+
+		hosts := certConfig.Hosts
+		if len(hosts) == 0 {
+			hosts = hostnames
+		}
+
+		for _, host := range hosts {
+			host = normalizeServerName(host)
+			if _, ok := s.hosts[host]; ok {
+				s.logger.Warn("Overriding certificate for host", "host", host, "cert_file", certConfig.CertFile)
+			}
+			s.hosts[host] = entry
+
+			if err := certificate.Leaf.VerifyHostname(host); err != nil && !strings.HasPrefix(host, "*.") {
+				s.logger.Warn("Certificate does not cover host", "host", host, "cert_file", certConfig.CertFile)
+			}
+		}
+
+		if s.fallback == nil {
+			s.fallback = entry
+		}
+	}
+
+	return nil
+}
+
+// GetConfigForClient returns the TLS configuration of the host named in the handshake.
+func (s *CertificateStore) GetConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	entry := s.lookup(hello.ServerName)
+	if entry == nil {
+		return nil, errors.New("no certificate available for " + hello.ServerName)
+	}
+
+	return entry.config, nil
+}
+
+func (s *CertificateStore) lookup(serverName string) *hostEntry {
+	serverName = normalizeServerName(serverName)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if entry, ok := s.hosts[serverName]; ok {
+		return entry
+	}
+
+	if _, rest, found := strings.Cut(serverName, "."); found {
+		if entry, ok := s.hosts["*."+rest]; ok {
+			return entry
+		}
+	}
+
+	if serverName != "" {
+		s.logger.Debug("No certificate for server name, using fallback", "server_name", serverName)
+	}
+
+	return s.fallback
+}
+
+func newBaseConfig(tlsConfig *config.TLSConfig) (*tls.Config, error) {
+	minVersion := tlsConfig.MinVersion
+	if minVersion == "" {
+		minVersion = "1.2"
+	}
+
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported TLS version %q", minVersion)
+	}
+
+	cipherSuites, err := parseCipherSuites(tlsConfig.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:   version,
+		CipherSuites: cipherSuites,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}, nil
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	available := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		available[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		available[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := available[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func normalizeServerName(serverName string) string {
+	return strings.TrimSuffix(strings.ToLower(serverName), ".")
+}
@@ -0,0 +1,144 @@
+// Package clientip provides functionality to find the address of the client behind trusted proxies.
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+)
+
+var defaultHeaders = []string{"X-Forwarded-For", "Forwarded", "X-Real-IP"}
+
+type resolver struct {
+	trusted []*net.IPNet
+	headers []string
+}
+
+var current atomic.Pointer[resolver]
+
+// SetTrustedProxies applies the trusted_proxies and client_ip_headers settings of the
+// global configuration. CIDRs are validated when the configuration is loaded.
+func SetTrustedProxies(globalConfig *config.GlobalConfig) {
+	r := &resolver{headers: defaultHeaders}
+	if len(globalConfig.ClientIPHeaders) > 0 {
+		r.headers = globalConfig.ClientIPHeaders
+	}
+
+	for _, cidr := range globalConfig.TrustedProxies {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			r.trusted = append(r.trusted, ipNet)
+		}
+	}
+
+	current.Store(r)
+}
+
+func getResolver() *resolver {
+	if r := current.Load(); r != nil {
+		return r
+	}
+	return &resolver{headers: defaultHeaders}
+}
+
+// HasTrustedProxies reports whether trusted_proxies is configured.
+func HasTrustedProxies() bool {
+	return len(getResolver().trusted) > 0
+}
+
+// IsTrusted reports whether ip belongs to trusted_proxies.
+func IsTrusted(ip net.IP) bool {
+	for _, ipNet := range getResolver().trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the IP address of the client of a request. When the peer is a
+// trusted proxy, the first of client_ip_headers present in the request is walked from
+// the closest hop back, skipping trusted proxies, and the first untrusted address is
+// the client. Otherwise the peer is the client.
+func Resolve(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+
+	peerIP := net.ParseIP(peer)
+	if peerIP == nil || !IsTrusted(peerIP) {
+		return peer
+	}
+
+	for _, header := range getResolver().headers {
+		var hops []string
+		if strings.EqualFold(header, "Forwarded") {
+			hops = forwardedFor(r.Header.Values(header))
+		} else {
+			hops = splitList(r.Header.Values(header))
+		}
+		if len(hops) == 0 {
+			continue
+		}
+
+		client := peerIP
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := parseHop(hops[i])
+			if ip == nil {
+				break
+			}
+			client = ip
+			if !IsTrusted(ip) {
+				break
+			}
+		}
+		return client.String()
+	}
+
+	return peer
+}
+
+func splitList(values []string) []string {
+	hops := []string{}
+	for _, value := range values {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// forwardedFor returns the for parameters of RFC 7239 Forwarded headers.
+func forwardedFor(values []string) []string {
+	hops := []string{}
+	for _, element := range splitList(values) {
+		for _, pair := range strings.Split(element, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(key, "for") {
+				hops = append(hops, value)
+			}
+		}
+	}
+	return hops
+}
+
+// parseHop parses an address of a forwarding header, which may be quoted and carry a
+// port. Obfuscated identifiers and "unknown" return nil.
+func parseHop(hop string) net.IP {
+	hop = strings.Trim(strings.TrimSpace(hop), `"`)
+	if strings.HasPrefix(hop, "[") {
+		end := strings.IndexByte(hop, ']')
+		if end < 0 {
+			return nil
+		}
+		hop = hop[1:end]
+	} else if strings.Count(hop, ":") == 1 {
+		hop, _, _ = strings.Cut(hop, ":")
+	}
+	return net.ParseIP(hop)
+}
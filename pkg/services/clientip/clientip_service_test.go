@@ -0,0 +1,143 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+)
+
+func TestResolve(t *testing.T) {
+	trustedProxies := []string{"10.0.0.0/8", "2001:db8:ffff::/48"}
+
+	tests := []struct {
+		name       string
+		headers    []string
+		remoteAddr string
+		values     map[string][]string
+		want       string
+	}{
+		{
+			name:       "untrusted peer ignores headers",
+			remoteAddr: "198.51.100.7:4000",
+			values:     map[string][]string{"X-Forwarded-For": {"203.0.113.5"}},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "trusted peer without headers",
+			remoteAddr: "10.0.0.1:4000",
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "X-Forwarded-For through a chain of trusted proxies",
+			remoteAddr: "10.0.0.1:4000",
+			values:     map[string][]string{"X-Forwarded-For": {"203.0.113.5, 10.1.1.1, 10.2.2.2"}},
+			want:       "203.0.113.5",
+		},
+		{
+			name:       "X-Forwarded-For stops at the first untrusted hop",
+			remoteAddr: "10.0.0.1:4000",
+			values:     map[string][]string{"X-Forwarded-For": {"192.0.2.1, 203.0.113.5, 10.2.2.2"}},
+			want:       "203.0.113.5",
+		},
+		{
+			name:       "X-Forwarded-For across several header lines",
+			remoteAddr: "10.0.0.1:4000",
+			values:     map[string][]string{"X-Forwarded-For": {"203.0.113.5", "10.2.2.2"}},
+			want:       "203.0.113.5",
+		},
+		{
+			name:       "X-Forwarded-For of trusted proxies only",
+			remoteAddr: "10.0.0.1:4000",
+			values:     map[string][]string{"X-Forwarded-For": {"10.1.1.1, 10.2.2.2"}},
+			want:       "10.1.1.1",
+		},
+		{
+			name:       "X-Forwarded-For with ports",
+			remoteAddr: "10.0.0.1:4000",
+			values:     map[string][]string{"X-Forwarded-For": {"203.0.113.5:1234, [2001:db8:ffff::1]:443"}},
+			want:       "203.0.113.5",
+		},
+		{
+			name:       "invalid hop stops the walk",
+			remoteAddr: "10.0.0.1:4000",
+			values:     map[string][]string{"X-Forwarded-For": {"203.0.113.5, garbage, 10.2.2.2"}},
+			want:       "10.2.2.2",
+		},
+		{
+			name:       "Forwarded through a chain of trusted proxies",
+			remoteAddr: "10.0.0.1:4000",
+			values:     map[string][]string{"Forwarded": {`for=192.0.2.60;proto=https, for="[2001:db8:ffff::17]:4711";by=10.0.0.1`}},
+			want:       "192.0.2.60",
+		},
+		{
+			name:       "Forwarded with an IPv6 client",
+			remoteAddr: "[2001:db8:ffff::2]:4000",
+			values:     map[string][]string{"Forwarded": {`for="[2001:db8:cafe::17]:4711"`, "for=10.3.3.3"}},
+			want:       "2001:db8:cafe::17",
+		},
+		{
+			name:       "Forwarded with an obfuscated hop",
+			remoteAddr: "10.0.0.1:4000",
+			values:     map[string][]string{"Forwarded": {"for=192.0.2.60, for=_hidden, for=10.3.3.3"}},
+			want:       "10.3.3.3",
+		},
+		{
+			name:       "X-Forwarded-For is preferred to Forwarded",
+			remoteAddr: "10.0.0.1:4000",
+			values: map[string][]string{
+				"X-Forwarded-For": {"203.0.113.5"},
+				"Forwarded":       {"for=192.0.2.60"},
+			},
+			want: "203.0.113.5",
+		},
+		{
+			name:       "X-Real-IP is used when the other headers are missing",
+			remoteAddr: "10.0.0.1:4000",
+			values:     map[string][]string{"X-Real-IP": {"203.0.113.5"}},
+			want:       "203.0.113.5",
+		},
+		{
+			name:       "configured headers replace the defaults",
+			headers:    []string{"CF-Connecting-IP"},
+			remoteAddr: "10.0.0.1:4000",
+			values: map[string][]string{
+				"X-Forwarded-For":  {"203.0.113.5"},
+				"CF-Connecting-IP": {"192.0.2.60"},
+			},
+			want: "192.0.2.60",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetTrustedProxies(&config.GlobalConfig{TrustedProxies: trustedProxies, ClientIPHeaders: tt.headers})
+			t.Cleanup(func() { current.Store(nil) })
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for key, values := range tt.values {
+				for _, value := range values {
+					r.Header.Add(key, value)
+				}
+			}
+
+			if got := Resolve(r); got != tt.want {
+				t.Errorf("Resolve() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveWithoutTrustedProxies(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:4000"
+	r.Header.Set("X-Forwarded-For", "203.0.113.5")
+
+	if HasTrustedProxies() {
+		t.Fatal("expected no trusted proxies by default")
+	}
+	if got := Resolve(r); got != "10.0.0.1" {
+		t.Errorf("Resolve() = %s, want the peer address", got)
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/letronghoangminh/reproxy/pkg/config"
 	"github.com/letronghoangminh/reproxy/pkg/interfaces"
+	"github.com/letronghoangminh/reproxy/pkg/services/metrics"
 	"github.com/letronghoangminh/reproxy/pkg/utils"
 )
 
@@ -69,8 +70,10 @@ func (r *DNSResolver) GetDynamicUpstreams(dynamicUpstreams []config.DynamicUpstr
 		var records []string
 		if found && time.Now().Before(cache.ExpireAt) {
 			records = cache.Records
+			metrics.ObserveDNSCache(true)
 			utils.GetLogger().Debug("DNS cache hit", "domain", domain, "type", upstream.Type)
 		} else {
+			metrics.ObserveDNSCache(false)
 			utils.GetLogger().Debug("DNS cache miss", "domain", domain, "type", upstream.Type)
 
 			var lookupErr error
@@ -132,3 +135,7 @@ var DefaultDNSResolver = NewDNSResolver(5 * time.Minute)
 func GetDynamicUpstreams(dynamicUpstreams []config.DynamicUpstreamConfig) ([]string, error) {
 	return DefaultDNSResolver.GetDynamicUpstreams(dynamicUpstreams)
 }
+
+func ClearCache() {
+	DefaultDNSResolver.ClearCache()
+}
@@ -0,0 +1,223 @@
+package matcher
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+)
+
+// condition is a compiled check of a request.
+type condition func(r *http.Request) bool
+
+// compiledMatcher is the compiled matchers block of a handler. The conditions are
+// ANDed; the top level path is kept apart since its prefix and captures are recorded
+// on the request.
+type compiledMatcher struct {
+	path       *PathPattern
+	conditions []condition
+}
+
+func compileMatcher(matchersConfig *config.MatchersConfig) (*compiledMatcher, error) {
+	m := &compiledMatcher{}
+
+	if matchersConfig.Path != "" {
+		pattern, err := getPathPattern(matchersConfig)
+		if err != nil {
+			return nil, err
+		}
+		m.path = pattern
+	}
+
+	if len(matchersConfig.Method) > 0 && !slices.Contains(matchersConfig.Method, "*") {
+		methods := matchersConfig.Method
+		m.add(func(r *http.Request) bool {
+			return slices.Contains(methods, r.Method)
+		})
+	}
+
+	for key, value := range matchersConfig.Headers {
+		m.add(func(r *http.Request) bool {
+			return r.Header.Get(key) == value
+		})
+	}
+
+	for key, expr := range matchersConfig.HeaderRegexp {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid header_regexp %q for %s: %w", expr, key, err)
+		}
+		m.add(func(r *http.Request) bool {
+			return slices.ContainsFunc(r.Header.Values(key), re.MatchString)
+		})
+	}
+
+	for _, key := range matchersConfig.HeaderPresent {
+		m.add(func(r *http.Request) bool {
+			return len(r.Header.Values(key)) > 0
+		})
+	}
+
+	for _, key := range matchersConfig.HeaderAbsent {
+		m.add(func(r *http.Request) bool {
+			return len(r.Header.Values(key)) == 0
+		})
+	}
+
+	for key, value := range matchersConfig.Query {
+		m.add(func(r *http.Request) bool {
+			return r.URL.Query().Get(key) == value
+		})
+	}
+
+	for key, expr := range matchersConfig.QueryRegexp {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query_regexp %q for %s: %w", expr, key, err)
+		}
+		m.add(func(r *http.Request) bool {
+			return slices.ContainsFunc(r.URL.Query()[key], re.MatchString)
+		})
+	}
+
+	if len(matchersConfig.ClientCIDRs) > 0 {
+		ipNets := make([]*net.IPNet, 0, len(matchersConfig.ClientCIDRs))
+		for _, cidr := range matchersConfig.ClientCIDRs {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid client CIDR %q: %w", cidr, err)
+			}
+			ipNets = append(ipNets, ipNet)
+		}
+		m.add(func(r *http.Request) bool {
+			ip := net.ParseIP(utils.RemoteIP(r))
+			return ip != nil && slices.ContainsFunc(ipNets, func(ipNet *net.IPNet) bool {
+				return ipNet.Contains(ip)
+			})
+		})
+	}
+
+	if len(matchersConfig.Protocol) > 0 {
+		protocols := matchersConfig.Protocol
+		m.add(func(r *http.Request) bool {
+			return slices.ContainsFunc(protocols, func(protocol string) bool {
+				return matchProtocol(r, protocol)
+			})
+		})
+	}
+
+	if len(matchersConfig.SNI) > 0 {
+		serverNames := NewHostRouter()
+		for _, serverName := range matchersConfig.SNI {
+			if !strings.HasPrefix(serverName, "~") {
+				serverName = NormalizeHost(serverName)
+			}
+			if err := serverNames.Add(serverName); err != nil {
+				return nil, err
+			}
+		}
+		m.add(func(r *http.Request) bool {
+			if r.TLS == nil || r.TLS.ServerName == "" {
+				return false
+			}
+			_, ok := serverNames.Resolve(NormalizeHost(r.TLS.ServerName))
+			return ok
+		})
+	}
+
+	if matchersConfig.Not != nil {
+		not, err := compileMatcher(matchersConfig.Not)
+		if err != nil {
+			return nil, err
+		}
+		m.add(func(r *http.Request) bool {
+			return !not.matches(r)
+		})
+	}
+
+	if len(matchersConfig.AnyOf) > 0 {
+		anyOf, err := compileMatchers(matchersConfig.AnyOf)
+		if err != nil {
+			return nil, err
+		}
+		m.add(func(r *http.Request) bool {
+			return slices.ContainsFunc(anyOf, func(m *compiledMatcher) bool {
+				return m.matches(r)
+			})
+		})
+	}
+
+	if len(matchersConfig.AllOf) > 0 {
+		allOf, err := compileMatchers(matchersConfig.AllOf)
+		if err != nil {
+			return nil, err
+		}
+		m.add(func(r *http.Request) bool {
+			for _, m := range allOf {
+				if !m.matches(r) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+
+	return m, nil
+}
+
+func compileMatchers(matchersConfigs []config.MatchersConfig) ([]*compiledMatcher, error) {
+	matchers := make([]*compiledMatcher, 0, len(matchersConfigs))
+	for i := range matchersConfigs {
+		m, err := compileMatcher(&matchersConfigs[i])
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+func (m *compiledMatcher) add(c condition) {
+	m.conditions = append(m.conditions, c)
+}
+
+// conditionsMatch checks every condition but the path.
+func (m *compiledMatcher) conditionsMatch(r *http.Request) bool {
+	for _, c := range m.conditions {
+		if !c(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches checks a nested block, whose path only filters the request.
+func (m *compiledMatcher) matches(r *http.Request) bool {
+	if m.path != nil {
+		if _, _, ok := m.path.Match(r.URL.Path); !ok {
+			return false
+		}
+	}
+	return m.conditionsMatch(r)
+}
+
+// matchProtocol checks http and https against the client connection, and http1 and
+// http2 against the protocol version of the request.
+func matchProtocol(r *http.Request, protocol string) bool {
+	switch protocol {
+	case "http":
+		return r.TLS == nil
+	case "https":
+		return r.TLS != nil
+	case "http1":
+		return r.ProtoMajor == 1
+	case "http2":
+		return r.ProtoMajor == 2
+	}
+	return false
+}
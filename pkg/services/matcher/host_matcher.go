@@ -0,0 +1,150 @@
+package matcher
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CatchAllHost is the host pattern of the default server of a port, which serves the
+// requests whose Host header matches no other host of the port.
+const CatchAllHost = "*"
+
+// NormalizeHost lowercases a hostname and removes the trailing dot of fully qualified
+// names and the brackets of IPv6 literals.
+func NormalizeHost(hostname string) string {
+	hostname = strings.TrimPrefix(strings.TrimSuffix(hostname, "]"), "[")
+	return strings.TrimSuffix(strings.ToLower(hostname), ".")
+}
+
+// SplitListenerHost splits a host of the listener configuration into its pattern and
+// port. The port follows the last colon, so regex patterns and bracketed IPv6 literals
+// such as [::1]:8080 are accepted. Patterns other than regex ones are normalized.
+func SplitListenerHost(host string) (string, int, error) {
+	i := strings.LastIndexByte(host, ':')
+	if i < 0 {
+		return "", 0, fmt.Errorf("host %q has no port", host)
+	}
+
+	port, err := strconv.Atoi(host[i+1:])
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("host %q has an invalid port", host)
+	}
+
+	hostname := host[:i]
+	if hostname == "" {
+		return "", 0, fmt.Errorf("host %q has no hostname", host)
+	}
+	if strings.HasPrefix(hostname, "~") {
+		return hostname, port, nil
+	}
+	if strings.Contains(hostname, ":") && !strings.HasPrefix(hostname, "[") {
+		return "", 0, fmt.Errorf("IPv6 host %q must be written in brackets", host)
+	}
+	return NormalizeHost(hostname), port, nil
+}
+
+// SplitRequestHost splits a Host header into its normalized hostname and port, which is
+// defaultPort when the header has none. Bare IPv6 literals are tolerated.
+func SplitRequestHost(host string, defaultPort int) (string, int, error) {
+	switch {
+	case !strings.Contains(host, ":"),
+		strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]"),
+		!strings.HasPrefix(host, "[") && strings.Count(host, ":") > 1:
+		return NormalizeHost(host), defaultPort, nil
+	}
+
+	hostname, portStr, err := net.SplitHostPort(host)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port %q", portStr)
+	}
+	return NormalizeHost(hostname), port, nil
+}
+
+type hostRegexp struct {
+	regexp  *regexp.Regexp
+	pattern string
+}
+
+// HostRouter resolves the Host header of a request to the host pattern of a port that
+// serves it. Exact hosts win over wildcards, the longest wildcard wins, then regex
+// patterns are tried in configuration order and finally the default server.
+type HostRouter struct {
+	exact     map[string]bool
+	wildcards map[string]string
+	regexps   []hostRegexp
+	catchAll  bool
+}
+
+func NewHostRouter() *HostRouter {
+	return &HostRouter{
+		exact:     map[string]bool{},
+		wildcards: map[string]string{},
+	}
+}
+
+// Add registers a pattern returned by SplitListenerHost: an exact hostname, a wildcard
+// such as *.example.com matching any depth of subdomains, a regular expression
+// prefixed with ~ matched case-insensitively against the whole hostname, or * for the
+// default server.
+func (h *HostRouter) Add(pattern string) error {
+	switch {
+	case pattern == CatchAllHost:
+		h.catchAll = true
+	case strings.HasPrefix(pattern, "~"):
+		for _, r := range h.regexps {
+			if r.pattern == pattern {
+				return nil
+			}
+		}
+		re, err := regexp.Compile("(?i)^(?:" + pattern[1:] + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid host regex %q: %w", pattern[1:], err)
+		}
+		h.regexps = append(h.regexps, hostRegexp{regexp: re, pattern: pattern})
+	case strings.HasPrefix(pattern, "*."):
+		h.wildcards[pattern[2:]] = pattern
+	case strings.Contains(pattern, "*"):
+		return fmt.Errorf("invalid host %q, wildcards are only allowed as the first label", pattern)
+	default:
+		h.exact[pattern] = true
+	}
+	return nil
+}
+
+// Resolve returns the pattern serving a normalized hostname.
+func (h *HostRouter) Resolve(hostname string) (string, bool) {
+	if h.exact[hostname] {
+		return hostname, true
+	}
+
+	if net.ParseIP(hostname) == nil {
+		for rest := hostname; ; {
+			_, after, found := strings.Cut(rest, ".")
+			if !found {
+				break
+			}
+			if pattern, ok := h.wildcards[after]; ok {
+				return pattern, true
+			}
+			rest = after
+		}
+	}
+
+	for _, r := range h.regexps {
+		if r.regexp.MatchString(hostname) {
+			return r.pattern, true
+		}
+	}
+
+	if h.catchAll {
+		return CatchAllHost, true
+	}
+	return "", false
+}
@@ -0,0 +1,154 @@
+package matcher
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+)
+
+// captureName is the name of a {name} capture in exact, prefix and glob patterns.
+var captureName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// PathPattern is a compiled path matcher. Every mode is compiled to a regular
+// expression; in prefix and glob modes its first group is the prefix that handlers
+// strip, in exact and regex modes the match itself when it starts the path.
+type PathPattern struct {
+	regexp *regexp.Regexp
+	group  bool
+}
+
+var (
+	pathPatterns      = map[string]*PathPattern{}
+	pathPatternsMutex sync.RWMutex
+)
+
+// CompilePath compiles a path pattern. exact and prefix patterns are literal paths
+// where {name} captures one segment; prefix patterns match whole segments only, so
+// /api matches /api/users but not /apiary. glob patterns also accept * within a
+// segment, ** across segments and ? for one character. regex patterns are Go regular
+// expressions whose named groups are captures.
+func CompilePath(pattern, mode string) (*PathPattern, error) {
+	var expr string
+	switch mode {
+	case "regex":
+		expr = pattern
+	case "exact":
+		expr = "^" + translatePath(pattern, false) + "$"
+	case "glob":
+		dir := globDir(pattern)
+		expr = "^(" + translatePath(dir, true) + ")" + translatePath(pattern[len(dir):], true) + "$"
+	case "", "prefix":
+		expr = "^(" + translatePath(pattern, false) + ")"
+		if !strings.HasSuffix(pattern, "/") {
+			expr += "(?:/|$)"
+		}
+	default:
+		return nil, fmt.Errorf("invalid path mode %q", mode)
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %q: %w", pattern, err)
+	}
+	return &PathPattern{regexp: re, group: mode == "" || mode == "prefix" || mode == "glob"}, nil
+}
+
+// globDir is the literal directory a glob pattern starts with, up to the slash before
+// its first wildcard or capture, or the whole pattern when it has none.
+func globDir(pattern string) string {
+	i := strings.IndexAny(pattern, "*?{")
+	if i < 0 {
+		return pattern
+	}
+	return pattern[:strings.LastIndexByte(pattern[:i], '/')+1]
+}
+
+// translatePath turns a literal or glob pattern into a regular expression.
+func translatePath(pattern string, glob bool) string {
+	var expr strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '{':
+			end := strings.IndexByte(pattern[i:], '}')
+			if end > 0 && captureName.MatchString(pattern[i+1:i+end]) {
+				expr.WriteString("(?P<" + pattern[i+1:i+end] + ">[^/]+)")
+				i += end
+				continue
+			}
+			expr.WriteString(regexp.QuoteMeta("{"))
+		case glob && strings.HasPrefix(pattern[i:], "**/"):
+			// **/ also matches no segment at all.
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case glob && strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case glob && c == '*':
+			expr.WriteString("[^/]*")
+		case glob && c == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return expr.String()
+}
+
+// Match reports whether path matches the pattern, with the prefix that handlers strip
+// from the path and the named captures.
+func (p *PathPattern) Match(path string) (prefix string, captures map[string]string, ok bool) {
+	match := p.regexp.FindStringSubmatchIndex(path)
+	if match == nil {
+		return "", nil, false
+	}
+
+	if p.group {
+		prefix = path[match[2]:match[3]]
+	} else if match[0] == 0 {
+		prefix = path[:match[1]]
+	}
+
+	for i, name := range p.regexp.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		if captures == nil {
+			captures = map[string]string{}
+		}
+		if match[2*i] >= 0 {
+			captures[name] = path[match[2*i]:match[2*i+1]]
+		} else {
+			captures[name] = ""
+		}
+	}
+
+	return prefix, captures, true
+}
+
+// getPathPattern returns the compiled pattern of a matcher, compiling it on first use.
+// Patterns are shared by every handler and configuration generation using them.
+func getPathPattern(matchersConfig *config.MatchersConfig) (*PathPattern, error) {
+	key := matchersConfig.PathMode + ":" + matchersConfig.Path
+
+	pathPatternsMutex.RLock()
+	pattern, ok := pathPatterns[key]
+	pathPatternsMutex.RUnlock()
+	if ok {
+		return pattern, nil
+	}
+
+	pattern, err := CompilePath(matchersConfig.Path, matchersConfig.PathMode)
+	if err != nil {
+		return nil, err
+	}
+
+	pathPatternsMutex.Lock()
+	pathPatterns[key] = pattern
+	pathPatternsMutex.Unlock()
+
+	return pattern, nil
+}
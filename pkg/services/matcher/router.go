@@ -0,0 +1,257 @@
+package matcher
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+)
+
+// route is a handler with its compiled matchers, at its rank in the router.
+type route struct {
+	handler *config.HandlerConfig
+	matcher *compiledMatcher
+}
+
+// routeNode is a path segment of the router tree. Handlers are stored by rank at the
+// node of the literal segments of their path; param holds the segments with captures.
+type routeNode struct {
+	children map[string]*routeNode
+	param    *routeNode
+	prefix   []int
+	exact    []int
+}
+
+// Shadow is a handler that never matches because a higher ranked handler without
+// conditions matches all of its requests.
+type Shadow struct {
+	Handler *config.HandlerConfig
+	By      *config.HandlerConfig
+}
+
+// Router is the interfaces.Matcher selecting the handler of a request among the
+// handlers of a host. Handlers are ranked by priority, then by the length of the literal
+// part of their path so that the most specific path wins, then exact before prefix,
+// glob and regex paths, and finally by configuration order. A tree of path segments
+// narrows down the handlers whose path can match, and the first of them in rank order
+// whose matchers all match serves the request.
+type Router struct {
+	routes    []route
+	root      *routeNode
+	unindexed []int
+}
+
+func NewRouter(handlers []*config.HandlerConfig) (*Router, error) {
+	ranked := slices.Clone(handlers)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := &ranked[i].Matchers, &ranked[j].Matchers
+		if ranked[i].Priority != ranked[j].Priority {
+			return ranked[i].Priority > ranked[j].Priority
+		}
+		if literalLength(a) != literalLength(b) {
+			return literalLength(a) > literalLength(b)
+		}
+		return pathKind(a) > pathKind(b)
+	})
+
+	router := &Router{root: &routeNode{}}
+	for i, handler := range ranked {
+		compiled, err := compileMatcher(&handler.Matchers)
+		if err != nil {
+			return nil, err
+		}
+		router.routes = append(router.routes, route{handler: handler, matcher: compiled})
+		router.insert(i, &handler.Matchers)
+	}
+
+	return router, nil
+}
+
+func (rt *Router) insert(rank int, matchersConfig *config.MatchersConfig) {
+	if matchersConfig.Path == "" || matchersConfig.PathMode == "regex" {
+		rt.unindexed = append(rt.unindexed, rank)
+		return
+	}
+
+	node := rt.root
+	for _, segment := range pathSegments(matchersConfig.Path) {
+		if matchersConfig.PathMode == "glob" {
+			// Glob wildcards may match empty or several segments, the handler is
+			// indexed at its literal prefix.
+			if strings.ContainsAny(segment, "*?{") {
+				break
+			}
+			node = node.child(segment)
+		} else if strings.Contains(segment, "{") {
+			if node.param == nil {
+				node.param = &routeNode{}
+			}
+			node = node.param
+		} else {
+			node = node.child(segment)
+		}
+	}
+
+	if matchersConfig.PathMode == "exact" {
+		node.exact = append(node.exact, rank)
+	} else {
+		node.prefix = append(node.prefix, rank)
+	}
+}
+
+func (n *routeNode) child(segment string) *routeNode {
+	if n.children == nil {
+		n.children = map[string]*routeNode{}
+	}
+	child, ok := n.children[segment]
+	if !ok {
+		child = &routeNode{}
+		n.children[segment] = child
+	}
+	return child
+}
+
+// collect adds the handlers whose path can match the remaining segments.
+func (n *routeNode) collect(segments []string, candidates []int) []int {
+	candidates = append(candidates, n.prefix...)
+	if len(segments) == 0 {
+		return append(candidates, n.exact...)
+	}
+
+	if child, ok := n.children[segments[0]]; ok {
+		candidates = child.collect(segments[1:], candidates)
+	}
+	if n.param != nil && segments[0] != "" {
+		candidates = n.param.collect(segments[1:], candidates)
+	}
+	return candidates
+}
+
+// Match returns the handler serving the request, or nil, and records the path prefix
+// and captures of the handler on the request.
+func (rt *Router) Match(r *http.Request) *config.HandlerConfig {
+	candidates := rt.root.collect(pathSegments(r.URL.Path), slices.Clone(rt.unindexed))
+	slices.Sort(candidates)
+
+	for _, rank := range candidates {
+		route := rt.routes[rank]
+		if !route.matcher.conditionsMatch(r) {
+			continue
+		}
+
+		var prefix string
+		var captures map[string]string
+		if route.matcher.path != nil {
+			var ok bool
+			prefix, captures, ok = route.matcher.path.Match(r.URL.Path)
+			if !ok {
+				continue
+			}
+		}
+
+		utils.GetLogger().Debug("Request matched", "rank", rank)
+		utils.GetRequestInfo(r.Context()).SetPathMatch(prefix, captures)
+		return route.handler
+	}
+
+	utils.GetLogger().Debug("No handler matched")
+	return nil
+}
+
+// Handlers returns the handlers in rank order.
+func (rt *Router) Handlers() []*config.HandlerConfig {
+	handlers := make([]*config.HandlerConfig, 0, len(rt.routes))
+	for _, route := range rt.routes {
+		handlers = append(handlers, route.handler)
+	}
+	return handlers
+}
+
+// Shadowed returns the handlers that can never match. Only handlers shadowed by a
+// handler without conditions besides its path are detected.
+func (rt *Router) Shadowed() []Shadow {
+	shadows := []Shadow{}
+	for j, shadowed := range rt.routes {
+		for _, by := range rt.routes[:j] {
+			if len(by.matcher.conditions) == 0 && coversPath(&by.handler.Matchers, &shadowed.handler.Matchers) {
+				shadows = append(shadows, Shadow{Handler: shadowed.handler, By: by.handler})
+				break
+			}
+		}
+	}
+	return shadows
+}
+
+// coversPath reports whether every path matched by b is matched by a.
+func coversPath(a, b *config.MatchersConfig) bool {
+	if a.Path == "" {
+		return true
+	}
+	if b.Path == "" {
+		return false
+	}
+	if pathKind(a) == pathKind(b) && a.Path == b.Path {
+		return true
+	}
+	if pathKind(a) != pathKindPrefix || strings.Contains(a.Path, "{") || pathKind(b) == pathKindRegex {
+		return false
+	}
+
+	if strings.HasSuffix(a.Path, "/") {
+		return strings.HasPrefix(b.Path, a.Path)
+	}
+	return b.Path == a.Path || strings.HasPrefix(b.Path, a.Path+"/")
+}
+
+const (
+	pathKindNone = iota
+	pathKindRegex
+	pathKindGlob
+	pathKindPrefix
+	pathKindExact
+)
+
+func pathKind(matchersConfig *config.MatchersConfig) int {
+	if matchersConfig.Path == "" {
+		return pathKindNone
+	}
+	switch matchersConfig.PathMode {
+	case "regex":
+		return pathKindRegex
+	case "glob":
+		return pathKindGlob
+	case "exact":
+		return pathKindExact
+	}
+	return pathKindPrefix
+}
+
+// literalLength is the length of the path up to its first capture or wildcard, the
+// specificity used to rank handlers. Regex paths have none.
+func literalLength(matchersConfig *config.MatchersConfig) int {
+	switch pathKind(matchersConfig) {
+	case pathKindNone, pathKindRegex:
+		return 0
+	case pathKindGlob:
+		if i := strings.IndexAny(matchersConfig.Path, "*?{"); i >= 0 {
+			return i
+		}
+	default:
+		if i := strings.IndexByte(matchersConfig.Path, '{'); i >= 0 {
+			return i
+		}
+	}
+	return len(matchersConfig.Path)
+}
+
+// pathSegments splits a path into segments, ignoring its leading and trailing slash.
+func pathSegments(path string) []string {
+	path = strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
@@ -0,0 +1,435 @@
+package matcher
+
+import (
+	"context"
+	"maps"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/interfaces"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+)
+
+func handler(name, path, mode string, priority int, methods ...string) *config.HandlerConfig {
+	return &config.HandlerConfig{
+		Name:     name,
+		Priority: priority,
+		Matchers: config.MatchersConfig{Path: path, PathMode: mode, Method: methods},
+	}
+}
+
+func newTestRouter(t *testing.T, handlers ...*config.HandlerConfig) *Router {
+	t.Helper()
+
+	router, err := NewRouter(handlers)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	return router
+}
+
+func handlerName(handler *config.HandlerConfig) string {
+	if handler == nil {
+		return ""
+	}
+	return handler.Name
+}
+
+func TestRouterMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		handlers []*config.HandlerConfig
+		method   string
+		path     string
+		want     string
+		prefix   string
+		captures map[string]string
+	}{
+		{
+			name:     "prefix matches its own path",
+			handlers: []*config.HandlerConfig{handler("api", "/api", "", 0)},
+			path:     "/api",
+			want:     "api",
+			prefix:   "/api",
+		},
+		{
+			name:     "prefix matches nested segments",
+			handlers: []*config.HandlerConfig{handler("api", "/api", "", 0)},
+			path:     "/api/users",
+			want:     "api",
+			prefix:   "/api",
+		},
+		{
+			name:     "prefix does not match a longer segment",
+			handlers: []*config.HandlerConfig{handler("api", "/api", "", 0)},
+			path:     "/apiary",
+			want:     "",
+		},
+		{
+			name:     "sibling prefix with a longer segment",
+			handlers: []*config.HandlerConfig{handler("api", "/api", "", 0), handler("apiary", "/apiary", "", 0)},
+			path:     "/apiary/bees",
+			want:     "apiary",
+			prefix:   "/apiary",
+		},
+		{
+			name:     "prefix with a trailing slash requires it",
+			handlers: []*config.HandlerConfig{handler("static", "/static/", "", 0)},
+			path:     "/static",
+			want:     "",
+		},
+		{
+			name:     "prefix with a trailing slash matches below it",
+			handlers: []*config.HandlerConfig{handler("static", "/static/", "", 0)},
+			path:     "/static/app.js",
+			want:     "static",
+			prefix:   "/static/",
+		},
+		{
+			name:     "prefix without a trailing slash matches with one",
+			handlers: []*config.HandlerConfig{handler("docs", "/docs", "", 0)},
+			path:     "/docs/",
+			want:     "docs",
+			prefix:   "/docs",
+		},
+		{
+			name:     "exact does not match a trailing slash",
+			handlers: []*config.HandlerConfig{handler("about", "/about", "exact", 0)},
+			path:     "/about/",
+			want:     "",
+		},
+		{
+			name:     "param segment captures",
+			handlers: []*config.HandlerConfig{handler("orders", "/users/{id}/orders", "", 0)},
+			path:     "/users/42/orders/7",
+			want:     "orders",
+			prefix:   "/users/42/orders",
+			captures: map[string]string{"id": "42"},
+		},
+		{
+			name:     "param segment does not match an empty segment",
+			handlers: []*config.HandlerConfig{handler("orders", "/users/{id}/orders", "", 0)},
+			path:     "/users//orders",
+			want:     "",
+		},
+		{
+			name:     "param segment requires the following literal",
+			handlers: []*config.HandlerConfig{handler("orders", "/users/{id}/orders", "", 0)},
+			path:     "/users/42",
+			want:     "",
+		},
+		{
+			name: "literal segment beats a param segment",
+			handlers: []*config.HandlerConfig{
+				handler("param", "/users/{id}/orders", "", 0),
+				handler("me", "/users/me/orders", "", 0),
+			},
+			path:   "/users/me/orders",
+			want:   "me",
+			prefix: "/users/me/orders",
+		},
+		{
+			name: "param segment serves other values",
+			handlers: []*config.HandlerConfig{
+				handler("param", "/users/{id}/orders", "", 0),
+				handler("me", "/users/me/orders", "", 0),
+			},
+			path:     "/users/you/orders",
+			want:     "param",
+			prefix:   "/users/you/orders",
+			captures: map[string]string{"id": "you"},
+		},
+		{
+			name:     "glob **/ matches zero segments",
+			handlers: []*config.HandlerConfig{handler("css", "/assets/**/*.css", "glob", 0)},
+			path:     "/assets/site.css",
+			want:     "css",
+			prefix:   "/assets/",
+		},
+		{
+			name:     "glob **/ matches several segments",
+			handlers: []*config.HandlerConfig{handler("css", "/assets/**/*.css", "glob", 0)},
+			path:     "/assets/a/b/site.css",
+			want:     "css",
+			prefix:   "/assets/",
+		},
+		{
+			name:     "glob * stays within a segment",
+			handlers: []*config.HandlerConfig{handler("css", "/assets/*.css", "glob", 0)},
+			path:     "/assets/a/site.css",
+			want:     "",
+		},
+		{
+			name:     "glob extension must match",
+			handlers: []*config.HandlerConfig{handler("css", "/assets/**/*.css", "glob", 0)},
+			path:     "/assets/site.js",
+			want:     "",
+		},
+		{
+			name: "exact beats prefix of equal length",
+			handlers: []*config.HandlerConfig{
+				handler("prefix", "/health", "", 0),
+				handler("exact", "/health", "exact", 0),
+			},
+			path:   "/health",
+			want:   "exact",
+			prefix: "/health",
+		},
+		{
+			name: "prefix serves what exact does not",
+			handlers: []*config.HandlerConfig{
+				handler("prefix", "/health", "", 0),
+				handler("exact", "/health", "exact", 0),
+			},
+			path:   "/health/live",
+			want:   "prefix",
+			prefix: "/health",
+		},
+		{
+			name: "longest literal wins regardless of order",
+			handlers: []*config.HandlerConfig{
+				handler("api", "/api", "", 0),
+				handler("v2", "/api/v2", "", 0),
+			},
+			path:   "/api/v2/users",
+			want:   "v2",
+			prefix: "/api/v2",
+		},
+		{
+			name: "priority beats specificity",
+			handlers: []*config.HandlerConfig{
+				handler("v2", "/api/v2", "", 0),
+				handler("root", "/", "", 10),
+			},
+			path:   "/api/v2/users",
+			want:   "root",
+			prefix: "/",
+		},
+		{
+			name: "priority ties fall back to configuration order",
+			handlers: []*config.HandlerConfig{
+				handler("first", "/x", "", 5),
+				handler("second", "/x", "", 5),
+			},
+			path:   "/x",
+			want:   "first",
+			prefix: "/x",
+		},
+		{
+			name: "conditions of a higher ranked handler are checked",
+			handlers: []*config.HandlerConfig{
+				handler("post", "/x", "", 0, "POST"),
+				handler("any", "/x", "", 0),
+			},
+			method: "GET",
+			path:   "/x",
+			want:   "any",
+			prefix: "/x",
+		},
+		{
+			name: "regex handlers are not indexed but still match",
+			handlers: []*config.HandlerConfig{
+				handler("api", "/api", "", 0),
+				handler("regex", `^/files/(?P<name>[a-z]+)\.txt$`, "regex", 0),
+			},
+			path:     "/files/notes.txt",
+			want:     "regex",
+			prefix:   "/files/notes.txt",
+			captures: map[string]string{"name": "notes"},
+		},
+		{
+			name:     "regex strips the match at the start of the path",
+			handlers: []*config.HandlerConfig{handler("versioned", `^/v(?P<version>[0-9]+)/`, "regex", 0)},
+			path:     "/v2/users",
+			want:     "versioned",
+			prefix:   "/v2/",
+			captures: map[string]string{"version": "2"},
+		},
+		{
+			name:     "regex strips nothing when matching within the path",
+			handlers: []*config.HandlerConfig{handler("json", `\.json$`, "regex", 0)},
+			path:     "/data/users.json",
+			want:     "json",
+		},
+		{
+			name:     "glob without wildcards strips the whole path",
+			handlers: []*config.HandlerConfig{handler("robots", "/robots.txt", "glob", 0)},
+			path:     "/robots.txt",
+			want:     "robots",
+			prefix:   "/robots.txt",
+		},
+		{
+			name: "handlers without path are a fallback",
+			handlers: []*config.HandlerConfig{
+				handler("fallback", "", "", 0),
+				handler("api", "/api", "", 0),
+			},
+			path: "/other",
+			want: "fallback",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t, tt.handlers...)
+
+			method := tt.method
+			if method == "" {
+				method = "GET"
+			}
+			ctx, info := utils.WithRequestInfo(context.Background())
+			r := httptest.NewRequestWithContext(ctx, method, tt.path, nil)
+
+			got := router.Match(r)
+			if handlerName(got) != tt.want {
+				t.Fatalf("Match(%s %s) = %q, want %q", method, tt.path, handlerName(got), tt.want)
+			}
+			if got == nil {
+				return
+			}
+			if info.PathPrefix() != tt.prefix {
+				t.Errorf("prefix = %q, want %q", info.PathPrefix(), tt.prefix)
+			}
+			if !maps.Equal(info.Captures(), tt.captures) {
+				t.Errorf("captures = %v, want %v", info.Captures(), tt.captures)
+			}
+		})
+	}
+}
+
+func TestRouterHandlersRankOrder(t *testing.T) {
+	router := newTestRouter(t,
+		handler("fallback", "", "", 0),
+		handler("regex", "^/r", "regex", 0),
+		handler("glob", "/api/*.json", "glob", 0),
+		handler("prefix", "/api", "", 0),
+		handler("exact", "/api", "exact", 0),
+		handler("long", "/api/users", "", 0),
+		handler("param", "/api/{id}", "", 0),
+		handler("urgent", "/", "", 1),
+	)
+
+	var got []string
+	for _, h := range router.Handlers() {
+		got = append(got, h.Name)
+	}
+
+	// "/api/" is the literal part of the param and glob paths, longer than "/api".
+	want := []string{"urgent", "long", "param", "glob", "exact", "prefix", "regex", "fallback"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Handlers() = %v, want %v", got, want)
+	}
+}
+
+func TestRouterShadowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		handlers []*config.HandlerConfig
+		want     map[string]string
+	}{
+		{
+			name: "higher priority prefix covers a nested path",
+			handlers: []*config.HandlerConfig{
+				handler("api", "/api", "", 1),
+				handler("users", "/api/users", "", 0),
+			},
+			want: map[string]string{"users": "api"},
+		},
+		{
+			name: "more specific path is not shadowed",
+			handlers: []*config.HandlerConfig{
+				handler("api", "/api", "", 0),
+				handler("users", "/api/users", "", 0),
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "prefix does not cover a longer segment",
+			handlers: []*config.HandlerConfig{
+				handler("api", "/api", "", 1),
+				handler("apiary", "/apiary", "", 0),
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "prefix with a trailing slash does not cover its bare path",
+			handlers: []*config.HandlerConfig{
+				handler("static", "/static/", "", 1),
+				handler("index", "/static", "exact", 0),
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "duplicate handler is shadowed by the first",
+			handlers: []*config.HandlerConfig{
+				handler("first", "/x", "exact", 0),
+				handler("second", "/x", "exact", 0),
+			},
+			want: map[string]string{"second": "first"},
+		},
+		{
+			name: "handler without path covers everything below it",
+			handlers: []*config.HandlerConfig{
+				handler("all", "", "", 5),
+				handler("api", "/api", "", 0),
+				handler("regex", "^/r", "regex", 0),
+			},
+			want: map[string]string{"api": "all", "regex": "all"},
+		},
+		{
+			name: "handler with conditions does not shadow",
+			handlers: []*config.HandlerConfig{
+				handler("post", "/api", "", 1, "POST"),
+				handler("users", "/api/users", "", 0),
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "param prefix does not shadow",
+			handlers: []*config.HandlerConfig{
+				handler("param", "/users/{id}", "", 1),
+				handler("orders", "/users/42/orders", "", 0),
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "regex path is never shadowed by a prefix",
+			handlers: []*config.HandlerConfig{
+				handler("api", "/api", "", 1),
+				handler("regex", "^/api/x", "regex", 0),
+			},
+			want: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t, tt.handlers...)
+
+			got := map[string]string{}
+			for _, shadow := range router.Shadowed() {
+				got[shadow.Handler.Name] = shadow.By.Name
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("Shadowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRouterRejectsInvalidPatterns(t *testing.T) {
+	if _, err := NewRouter([]*config.HandlerConfig{handler("bad", "/a(", "regex", 0)}); err == nil {
+		t.Error("expected an error for an invalid regex path")
+	}
+}
+
+func TestRouterIsAMatcher(t *testing.T) {
+	var m interfaces.Matcher = newTestRouter(t, handler("api", "/api", "", 0))
+
+	r := httptest.NewRequest("GET", "/api/users", nil)
+	if got := handlerName(m.Match(r)); got != "api" {
+		t.Errorf("Match() = %q, want api", got)
+	}
+}
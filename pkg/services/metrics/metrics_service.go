@@ -0,0 +1,153 @@
+// Package metrics provides Prometheus metrics for proxied requests, backends, health checks and DNS lookups.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "reproxy"
+
+// BackendStat is a point-in-time view of one backend of a handler.
+type BackendStat struct {
+	Handler           string
+	Upstream          string
+	ActiveConnections int
+	Alive             bool
+}
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of requests handled, by listener port, host, handler, upstream and status code class.",
+	}, []string{"port", "host", "handler", "upstream", "code"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time spent handling requests, by listener port, host, handler and upstream.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"port", "host", "handler", "upstream"})
+
+	healthChecksTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "health_checks_total",
+		Help:      "Number of backend health checks, by upstream and result.",
+	}, []string{"upstream", "result"})
+
+	retriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_retries_total",
+		Help:      "Number of retries triggered by failed upstream requests, by failing upstream.",
+	}, []string{"upstream"})
+
+	dnsCacheTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dns_cache_requests_total",
+		Help:      "Number of dynamic upstream DNS cache lookups, by result.",
+	}, []string{"result"})
+
+	backendSource atomic.Pointer[func() []BackendStat]
+
+	registry = prometheus.NewRegistry()
+)
+
+func init() {
+	registry.MustRegister(
+		requestsTotal,
+		requestDuration,
+		healthChecksTotal,
+		retriesTotal,
+		dnsCacheTotal,
+		&backendCollector{},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+func ObserveRequest(port int, host, handler, upstream string, status int, duration time.Duration) {
+	portLabel := strconv.Itoa(port)
+	requestsTotal.WithLabelValues(portLabel, host, handler, upstream, statusClass(status)).Inc()
+	requestDuration.WithLabelValues(portLabel, host, handler, upstream).Observe(duration.Seconds())
+}
+
+func ObserveHealthCheck(upstream string, alive bool) {
+	result := "up"
+	if !alive {
+		result = "down"
+	}
+	healthChecksTotal.WithLabelValues(upstream, result).Inc()
+}
+
+func ObserveRetry(upstream string) {
+	retriesTotal.WithLabelValues(upstream).Inc()
+}
+
+func ObserveDNSCache(hit bool) {
+	result := "hit"
+	if !hit {
+		result = "miss"
+	}
+	dnsCacheTotal.WithLabelValues(result).Inc()
+}
+
+// SetBackendSource installs the function listing backends at scrape time, so that
+// backends added or removed at runtime are always reported accurately.
+func SetBackendSource(source func() []BackendStat) {
+	backendSource.Store(&source)
+}
+
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+var (
+	activeConnectionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "backend", "active_connections"),
+		"Number of in-flight requests and tunnels per backend.",
+		[]string{"handler", "upstream"}, nil,
+	)
+	backendUpDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "backend", "up"),
+		"Whether the backend passed its last health check.",
+		[]string{"handler", "upstream"}, nil,
+	)
+)
+
+type backendCollector struct{}
+
+func (c *backendCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- activeConnectionsDesc
+	ch <- backendUpDesc
+}
+
+func (c *backendCollector) Collect(ch chan<- prometheus.Metric) {
+	source := backendSource.Load()
+	if source == nil {
+		return
+	}
+
+	for _, stat := range (*source)() {
+		up := 0.0
+		if stat.Alive {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(activeConnectionsDesc, prometheus.GaugeValue, float64(stat.ActiveConnections), stat.Handler, stat.Upstream)
+		ch <- prometheus.MustNewConstMetric(backendUpDesc, prometheus.GaugeValue, up, stat.Handler, stat.Upstream)
+	}
+}
@@ -2,21 +2,57 @@
 package backend
 
 import (
+	"context"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"slices"
 	"sync"
+	"time"
 
+	"github.com/letronghoangminh/reproxy/pkg/config"
 	"github.com/letronghoangminh/reproxy/pkg/interfaces"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
 )
 
+type serveStartKey struct{}
+
+// ServeStart returns when the backend started serving the request carrying ctx, which
+// lets response hooks measure the upstream latency.
+func ServeStart(ctx context.Context) time.Time {
+	start, _ := ctx.Value(serveStartKey{}).(time.Time)
+	return start
+}
+
+type (
+	cookiesKey   struct{}
+	admissionKey struct{}
+)
+
+// SetCookies adds the cookies of the backend serving the request carrying ctx to its
+// response. They are set on the response of the attempt that answers, so that a retry
+// on another backend does not send the cookies of both.
+func SetCookies(ctx context.Context, header http.Header) {
+	cookies, _ := ctx.Value(cookiesKey{}).([]*http.Cookie)
+	for _, cookie := range cookies {
+		if v := cookie.String(); v != "" {
+			header.Add("Set-Cookie", v)
+		}
+	}
+}
+
 type backend struct {
 	url          *url.URL
+	weight       int
 	alive        bool
+	disabled     bool
+	draining     bool
+	ejected      bool
 	mux          sync.RWMutex
 	connections  int
 	reverseProxy *httputil.ReverseProxy
 	cookies      []*http.Cookie
+	breaker      *circuitBreaker
 }
 
 func (b *backend) GetActiveConnections() int {
@@ -39,11 +75,87 @@ func (b *backend) IsAlive() bool {
 	return alive
 }
 
+func (b *backend) SetDisabled(disabled bool) {
+	b.mux.Lock()
+	b.disabled = disabled
+	b.mux.Unlock()
+}
+
+func (b *backend) IsDisabled() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.disabled
+}
+
+func (b *backend) SetDraining(draining bool) {
+	b.mux.Lock()
+	b.draining = draining
+	b.mux.Unlock()
+}
+
+func (b *backend) IsDraining() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.draining
+}
+
+func (b *backend) SetEjected(ejected bool) {
+	b.mux.Lock()
+	b.ejected = ejected
+	b.mux.Unlock()
+}
+
+func (b *backend) IsEjected() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.ejected
+}
+
+// IsAvailable reports whether the backend may receive new requests: it has to be
+// healthy, not ejected by outlier detection, neither disabled nor draining through
+// the admin API, and its circuit breaker must not be open.
+func (b *backend) IsAvailable() bool {
+	b.mux.RLock()
+	available := b.alive && !b.ejected && !b.disabled && !b.draining
+	b.mux.RUnlock()
+	return available && b.breaker.ready()
+}
+
+// RecordResult feeds the outcome of the request carrying ctx to the circuit breaker.
+func (b *backend) RecordResult(ctx context.Context, success bool) {
+	admitted, ok := ctx.Value(admissionKey{}).(admission)
+	if !ok {
+		return
+	}
+	b.breaker.record(admitted, success)
+}
+
+// GetCircuitState returns the state of the circuit breaker, or an empty string when
+// the backend has none.
+func (b *backend) GetCircuitState() string {
+	return b.breaker.getState()
+}
+
+func (b *backend) GetWeight() int {
+	return b.weight
+}
+
 func (b *backend) GetURL() *url.URL {
 	return b.url
 }
 
 func (b *backend) Serve(rw http.ResponseWriter, req *http.Request) {
+	// Another request may have taken the last half-open probe since the backend was
+	// chosen.
+	admitted, ok := b.breaker.acquire()
+	if !ok {
+		http.Error(rw, "Service not available", http.StatusServiceUnavailable)
+		return
+	}
+	if admitted.probe {
+		defer b.breaker.release()
+	}
+
 	defer func() {
 		b.mux.Lock()
 		b.connections--
@@ -54,29 +166,50 @@ func (b *backend) Serve(rw http.ResponseWriter, req *http.Request) {
 	b.connections++
 	b.mux.Unlock()
 
-	for _, cookie := range b.cookies {
-		http.SetCookie(rw, cookie)
-	}
+	utils.GetRequestInfo(req.Context()).SetUpstream(b.url.Host)
 
-	if b.reverseProxy.ModifyResponse == nil {
-		b.reverseProxy.ModifyResponse = func(resp *http.Response) error {
-			resp.Header.Set("X-Powered-By", "Reproxy")
-			return nil
-		}
-	}
+	b.mux.RLock()
+	cookies := slices.Clone(b.cookies)
+	b.mux.RUnlock()
+
+	ctx := context.WithValue(req.Context(), serveStartKey{}, time.Now())
+	ctx = context.WithValue(ctx, admissionKey{}, admitted)
+	req = req.WithContext(context.WithValue(ctx, cookiesKey{}, cookies))
 	b.reverseProxy.ServeHTTP(rw, req)
 }
 
+// AddCookie sets a cookie on the responses of the backend, replacing the cookie of the
+// same name.
 func (b *backend) AddCookie(cookie *http.Cookie) {
 	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	for i, existing := range b.cookies {
+		if existing.Name == cookie.Name {
+			b.cookies[i] = cookie
+			return
+		}
+	}
 	b.cookies = append(b.cookies, cookie)
-	b.mux.Unlock()
 }
 
-func NewBackend(u *url.URL, rp *httputil.ReverseProxy) interfaces.Backend {
+// NewBackend creates a backend receiving a share of traffic proportional to weight
+// with the weighted strategies. Weights below 1 count as 1. The backend gets a circuit
+// breaker when circuitBreakerConfig is set.
+func NewBackend(u *url.URL, weight int, rp *httputil.ReverseProxy, circuitBreakerConfig *config.CircuitBreakerConfig) interfaces.Backend {
+	if rp.ModifyResponse == nil {
+		rp.ModifyResponse = func(resp *http.Response) error {
+			resp.Header.Set("X-Powered-By", "Reproxy")
+			SetCookies(resp.Request.Context(), resp.Header)
+			return nil
+		}
+	}
+
 	return &backend{
 		url:          u,
+		weight:       max(weight, 1),
 		alive:        true,
 		reverseProxy: rp,
+		breaker:      newCircuitBreaker(u.String(), circuitBreakerConfig),
 	}
 }
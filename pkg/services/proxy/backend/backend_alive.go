@@ -2,20 +2,41 @@ package backend
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"net/url"
 
 	"github.com/letronghoangminh/reproxy/pkg/utils"
 )
 
-func IsBackendAlive(ctx context.Context, aliveChannel chan bool, u *url.URL) {
-	var d net.Dialer
-	conn, err := d.DialContext(ctx, "tcp", u.Host)
+// IsBackendAlive opens a connection to the backend. https backends must also complete a
+// TLS handshake with tlsConfig, which may be nil to use the system roots.
+func IsBackendAlive(ctx context.Context, aliveChannel chan bool, u *url.URL, tlsConfig *tls.Config) {
+	var conn net.Conn
+	var err error
+	if u.Scheme == "https" {
+		d := tls.Dialer{Config: tlsConfig}
+		conn, err = d.DialContext(ctx, "tcp", hostPort(u))
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", hostPort(u))
+	}
 	if err != nil {
-		utils.Logger.Debug("Site unreachable")
+		utils.Logger.Debug("Site unreachable", "error", err)
 		aliveChannel <- false
 		return
 	}
 	_ = conn.Close()
 	aliveChannel <- true
 }
+
+// hostPort adds the default port of the scheme to hosts without one.
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
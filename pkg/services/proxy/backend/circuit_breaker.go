@@ -0,0 +1,230 @@
+package backend
+
+import (
+	"sync"
+	"time"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// circuitBucket counts the requests completed during one second of the window.
+type circuitBucket struct {
+	second   int64
+	total    int
+	failures int
+}
+
+// admission is a request admitted by the circuit breaker, with the generation of the
+// breaker state it was admitted under.
+type admission struct {
+	generation uint64
+	probe      bool
+}
+
+// circuitBreaker stops sending requests to a failing backend. It opens when the
+// consecutive failures or the failure rate over a sliding window cross their threshold.
+// After the cool-down it turns half-open and lets a limited number of probe requests
+// through: the breaker closes once all of them succeeded and opens again on a failure.
+// Every change of state starts a new generation, and only the outcomes of requests
+// admitted in the current one are counted.
+type circuitBreaker struct {
+	url                 string
+	failureRate         int
+	minRequests         int
+	consecutiveFailures int
+	coolDown            time.Duration
+	probes              int
+
+	mux         sync.Mutex
+	state       string
+	buckets     []circuitBucket
+	consecutive int
+	openedAt    time.Time
+	inFlight    int
+	successes   int
+	generation  uint64
+}
+
+func newCircuitBreaker(url string, circuitBreakerConfig *config.CircuitBreakerConfig) *circuitBreaker {
+	if circuitBreakerConfig == nil {
+		return nil
+	}
+
+	return &circuitBreaker{
+		url:                 url,
+		failureRate:         utils.OrDefault(circuitBreakerConfig.FailureRate, 50),
+		minRequests:         utils.OrDefault(circuitBreakerConfig.MinRequests, 10),
+		consecutiveFailures: utils.OrDefault(circuitBreakerConfig.ConsecutiveFailures, 5),
+		coolDown:            time.Duration(utils.OrDefault(circuitBreakerConfig.CoolDown, 30)) * time.Second,
+		probes:              utils.OrDefault(circuitBreakerConfig.Probes, 1),
+		state:               CircuitClosed,
+		buckets:             make([]circuitBucket, utils.OrDefault(circuitBreakerConfig.Window, 10)),
+	}
+}
+
+// ready reports whether a request may be sent without reserving a probe, so that
+// server pools can check it while choosing a backend.
+func (cb *circuitBreaker) ready() bool {
+	if cb == nil {
+		return true
+	}
+
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	switch cb.currentState() {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		return cb.inFlight < cb.probes
+	default:
+		return true
+	}
+}
+
+// acquire admits a request. The admission is a probe when the breaker is half-open,
+// in which case release must be called once the request completed.
+func (cb *circuitBreaker) acquire() (admission, bool) {
+	if cb == nil {
+		return admission{}, true
+	}
+
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	switch cb.currentState() {
+	case CircuitOpen:
+		return admission{}, false
+	case CircuitHalfOpen:
+		if cb.inFlight >= cb.probes {
+			return admission{}, false
+		}
+		cb.inFlight++
+		return admission{generation: cb.generation, probe: true}, true
+	default:
+		return admission{generation: cb.generation}, true
+	}
+}
+
+func (cb *circuitBreaker) release() {
+	cb.mux.Lock()
+	cb.inFlight = max(cb.inFlight-1, 0)
+	cb.mux.Unlock()
+}
+
+// record counts the outcome of a request sent to the backend. Requests admitted under
+// an earlier state, such as those sent while the breaker was closed and completing
+// once it is half-open, are ignored.
+func (cb *circuitBreaker) record(admitted admission, success bool) {
+	if cb == nil {
+		return
+	}
+
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	state := cb.currentState()
+	if admitted.generation != cb.generation {
+		return
+	}
+
+	switch state {
+	case CircuitOpen:
+		return
+	case CircuitHalfOpen:
+		if !admitted.probe {
+			return
+		}
+		if !success {
+			cb.open("probe failed")
+			return
+		}
+		cb.successes++
+		if cb.successes >= cb.probes {
+			cb.close()
+		}
+		return
+	}
+
+	bucket := cb.bucket(time.Now().Unix())
+	bucket.total++
+	if success {
+		cb.consecutive = 0
+		return
+	}
+	bucket.failures++
+	cb.consecutive++
+
+	if cb.consecutive >= cb.consecutiveFailures {
+		cb.open("consecutive failures")
+		return
+	}
+
+	total, failures := cb.counts(time.Now().Unix())
+	if total >= cb.minRequests && failures*100 >= cb.failureRate*total {
+		cb.open("failure rate")
+	}
+}
+
+// currentState must be called with the lock held. An open breaker turns half-open
+// once the cool-down elapsed.
+func (cb *circuitBreaker) currentState() string {
+	if cb.state == CircuitOpen && time.Since(cb.openedAt) >= cb.coolDown {
+		cb.state = CircuitHalfOpen
+		cb.generation++
+		cb.inFlight = 0
+		cb.successes = 0
+		utils.Logger.Info("Circuit breaker half-open", "URL", cb.url)
+	}
+	return cb.state
+}
+
+func (cb *circuitBreaker) open(reason string) {
+	cb.state = CircuitOpen
+	cb.generation++
+	cb.openedAt = time.Now()
+	utils.Logger.Warn("Circuit breaker opened", "URL", cb.url, "reason", reason, "cool_down", cb.coolDown.String())
+}
+
+func (cb *circuitBreaker) close() {
+	cb.state = CircuitClosed
+	cb.generation++
+	cb.consecutive = 0
+	clear(cb.buckets)
+	utils.Logger.Info("Circuit breaker closed", "URL", cb.url)
+}
+
+func (cb *circuitBreaker) bucket(second int64) *circuitBucket {
+	bucket := &cb.buckets[second%int64(len(cb.buckets))]
+	if bucket.second != second {
+		*bucket = circuitBucket{second: second}
+	}
+	return bucket
+}
+
+func (cb *circuitBreaker) counts(now int64) (total, failures int) {
+	for _, bucket := range cb.buckets {
+		if now-bucket.second < int64(len(cb.buckets)) {
+			total += bucket.total
+			failures += bucket.failures
+		}
+	}
+	return total, failures
+}
+
+func (cb *circuitBreaker) getState() string {
+	if cb == nil {
+		return ""
+	}
+
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+	return cb.currentState()
+}
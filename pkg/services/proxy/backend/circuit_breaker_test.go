@@ -0,0 +1,229 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+)
+
+func newTestBreaker(t *testing.T, circuitBreakerConfig config.CircuitBreakerConfig) *circuitBreaker {
+	t.Helper()
+
+	utils.GetLogger()
+	return newCircuitBreaker("http://backend", &circuitBreakerConfig)
+}
+
+// coolDown moves the opening of the breaker back by its cool-down.
+func coolDown(cb *circuitBreaker) {
+	cb.mux.Lock()
+	cb.openedAt = cb.openedAt.Add(-cb.coolDown)
+	cb.mux.Unlock()
+}
+
+// recordAll admits a request for every result and records its outcome.
+func recordAll(cb *circuitBreaker, results ...bool) {
+	for _, success := range results {
+		admitted, _ := cb.acquire()
+		cb.record(admitted, success)
+	}
+}
+
+func TestCircuitBreakerDefaults(t *testing.T) {
+	cb := newTestBreaker(t, config.CircuitBreakerConfig{})
+
+	if cb.failureRate != 50 || cb.minRequests != 10 || cb.consecutiveFailures != 5 ||
+		cb.coolDown != 30*time.Second || cb.probes != 1 || len(cb.buckets) != 10 {
+		t.Errorf("unexpected defaults: %+v", cb)
+	}
+	if newCircuitBreaker("http://backend", nil) != nil {
+		t.Error("expected no breaker without configuration")
+	}
+}
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  config.CircuitBreakerConfig
+		results []bool
+		want    string
+	}{
+		{
+			name:    "consecutive failures",
+			config:  config.CircuitBreakerConfig{ConsecutiveFailures: 3, MinRequests: 100},
+			results: []bool{false, false, false},
+			want:    CircuitOpen,
+		},
+		{
+			name:    "a success resets consecutive failures",
+			config:  config.CircuitBreakerConfig{ConsecutiveFailures: 3, MinRequests: 100},
+			results: []bool{false, false, true, false, false},
+			want:    CircuitClosed,
+		},
+		{
+			name:    "failure rate",
+			config:  config.CircuitBreakerConfig{FailureRate: 50, MinRequests: 4, ConsecutiveFailures: 100},
+			results: []bool{true, false, true, false},
+			want:    CircuitOpen,
+		},
+		{
+			name:    "failure rate below the threshold",
+			config:  config.CircuitBreakerConfig{FailureRate: 50, MinRequests: 4, ConsecutiveFailures: 100},
+			results: []bool{true, true, true, false},
+			want:    CircuitClosed,
+		},
+		{
+			name:    "failure rate below min_requests",
+			config:  config.CircuitBreakerConfig{FailureRate: 50, MinRequests: 10, ConsecutiveFailures: 100},
+			results: []bool{false, true, false, true, false},
+			want:    CircuitClosed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := newTestBreaker(t, tt.config)
+			recordAll(cb, tt.results...)
+
+			if got := cb.getState(); got != tt.want {
+				t.Fatalf("state = %s, want %s", got, tt.want)
+			}
+			open := tt.want == CircuitOpen
+			if cb.ready() == open {
+				t.Errorf("ready() = %v with the breaker %s", cb.ready(), tt.want)
+			}
+			if _, ok := cb.acquire(); ok == open {
+				t.Errorf("acquire() admitted = %v with the breaker %s", ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	cb := newTestBreaker(t, config.CircuitBreakerConfig{ConsecutiveFailures: 1, Probes: 2})
+	early, _ := cb.acquire()
+	recordAll(cb, false)
+
+	// Outcomes of requests sent before the breaker opened are ignored.
+	cb.record(early, true)
+	if got := cb.getState(); got != CircuitOpen {
+		t.Fatalf("state = %s, want %s", got, CircuitOpen)
+	}
+
+	coolDown(cb)
+	if got := cb.getState(); got != CircuitHalfOpen {
+		t.Fatalf("state after the cool-down = %s, want %s", got, CircuitHalfOpen)
+	}
+
+	probes := make([]admission, 2)
+	for i := range probes {
+		var ok bool
+		if probes[i], ok = cb.acquire(); !probes[i].probe || !ok {
+			t.Fatalf("probe %d was not admitted", i+1)
+		}
+	}
+	if cb.ready() {
+		t.Error("ready() must be false while every probe is in flight")
+	}
+	if _, ok := cb.acquire(); ok {
+		t.Error("requests beyond the probes must be rejected")
+	}
+
+	cb.release()
+	cb.record(probes[0], true)
+	if got := cb.getState(); got != CircuitHalfOpen {
+		t.Fatalf("state after one successful probe = %s, want %s", got, CircuitHalfOpen)
+	}
+	cb.release()
+	cb.record(probes[1], true)
+	if got := cb.getState(); got != CircuitClosed {
+		t.Fatalf("state after every probe succeeded = %s, want %s", got, CircuitClosed)
+	}
+	if total, failures := cb.counts(time.Now().Unix()); total != 0 || failures != 0 {
+		t.Errorf("closing must reset the window, got %d requests and %d failures", total, failures)
+	}
+}
+
+func TestCircuitBreakerProbeFailureReopens(t *testing.T) {
+	cb := newTestBreaker(t, config.CircuitBreakerConfig{ConsecutiveFailures: 1, Probes: 2})
+	recordAll(cb, false)
+	coolDown(cb)
+
+	probe, ok := cb.acquire()
+	if !probe.probe || !ok {
+		t.Fatal("probe was not admitted")
+	}
+	cb.release()
+	cb.record(probe, false)
+
+	if got := cb.getState(); got != CircuitOpen {
+		t.Fatalf("state after a failed probe = %s, want %s", got, CircuitOpen)
+	}
+	if cb.ready() {
+		t.Error("a reopened breaker must wait for a new cool-down")
+	}
+}
+
+func TestCircuitBreakerIgnoresRequestsAdmittedWhileClosed(t *testing.T) {
+	cb := newTestBreaker(t, config.CircuitBreakerConfig{ConsecutiveFailures: 1})
+	slow, _ := cb.acquire()
+	recordAll(cb, false)
+	coolDown(cb)
+
+	probe, ok := cb.acquire()
+	if !probe.probe || !ok {
+		t.Fatal("probe was not admitted")
+	}
+
+	// A request sent while the breaker was closed completes during the probe.
+	cb.record(slow, true)
+	if got := cb.getState(); got != CircuitHalfOpen {
+		t.Fatalf("state after a success admitted while closed = %s, want %s", got, CircuitHalfOpen)
+	}
+	cb.record(slow, false)
+	if got := cb.getState(); got != CircuitHalfOpen {
+		t.Fatalf("state after a failure admitted while closed = %s, want %s", got, CircuitHalfOpen)
+	}
+
+	cb.release()
+	cb.record(probe, true)
+	if got := cb.getState(); got != CircuitClosed {
+		t.Fatalf("state after the probe succeeded = %s, want %s", got, CircuitClosed)
+	}
+
+	// Probes completing after the breaker closed do not count towards the window.
+	cb.record(probe, false)
+	if total, _ := cb.counts(time.Now().Unix()); total != 0 {
+		t.Errorf("a stale probe was counted, got %d requests", total)
+	}
+}
+
+func TestCircuitBreakerWindow(t *testing.T) {
+	cb := newTestBreaker(t, config.CircuitBreakerConfig{Window: 5, ConsecutiveFailures: 100, MinRequests: 100})
+	recordAll(cb, false, true, false)
+
+	now := time.Now().Unix()
+	if total, failures := cb.counts(now); total != 3 || failures != 2 {
+		t.Errorf("counts = %d requests and %d failures, want 3 and 2", total, failures)
+	}
+	if total, _ := cb.counts(now + 5); total != 0 {
+		t.Errorf("requests older than the window must not count, got %d", total)
+	}
+}
+
+func TestNilCircuitBreaker(t *testing.T) {
+	var cb *circuitBreaker
+
+	if !cb.ready() {
+		t.Error("a backend without breaker must be ready")
+	}
+	admitted, ok := cb.acquire()
+	if admitted.probe || !ok {
+		t.Error("a backend without breaker must admit every request")
+	}
+	cb.record(admitted, false)
+	if got := cb.getState(); got != "" {
+		t.Errorf("state = %q, want none", got)
+	}
+}
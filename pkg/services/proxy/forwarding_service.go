@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/services/clientip"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+)
+
+// forwardingHeaders are the headers copied to the outbound request by rewriteRequest,
+// since httputil.ReverseProxy removes them before Rewrite.
+var forwardingHeaders = []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto"}
+
+// applyForwarding sets the headers describing the original request following the
+// forwarding policy of the handler.
+func applyForwarding(r *http.Request, forwardingConfig *config.ForwardingConfig) {
+	mode := "auto"
+	forwarded := false
+	via := true
+	if forwardingConfig != nil {
+		if forwardingConfig.Mode != "" {
+			mode = forwardingConfig.Mode
+		}
+		forwarded = forwardingConfig.Forwarded
+		via = !forwardingConfig.DisableVia
+	}
+
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+
+	if mode == "auto" {
+		mode = "replace"
+		if ip := net.ParseIP(peer); ip != nil && clientip.IsTrusted(ip) {
+			mode = "append"
+		}
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	switch mode {
+	case "strip":
+		for _, header := range forwardingHeaders {
+			r.Header.Del(header)
+		}
+	case "replace":
+		client := utils.RemoteIP(r)
+		r.Header.Set("X-Forwarded-For", client)
+		r.Header.Set("X-Forwarded-Host", r.Host)
+		r.Header.Set("X-Forwarded-Proto", proto)
+		r.Header.Del("Forwarded")
+		if forwarded {
+			r.Header.Set("Forwarded", forwardedElement(client, r.Host, proto))
+		}
+	case "append":
+		// Proxies in front already described the original host and scheme.
+		if prior := r.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			r.Header.Set("X-Forwarded-For", strings.Join(prior, ", ")+", "+peer)
+		} else {
+			r.Header.Set("X-Forwarded-For", peer)
+		}
+		if r.Header.Get("X-Forwarded-Host") == "" {
+			r.Header.Set("X-Forwarded-Host", r.Host)
+		}
+		if r.Header.Get("X-Forwarded-Proto") == "" {
+			r.Header.Set("X-Forwarded-Proto", proto)
+		}
+		if forwarded {
+			r.Header.Add("Forwarded", forwardedElement(peer, r.Host, proto))
+		}
+	}
+
+	if via {
+		r.Header.Add("Via", fmt.Sprintf("%d.%d reproxy", r.ProtoMajor, r.ProtoMinor))
+	}
+}
+
+// forwardedElement formats an RFC 7239 element. IPv6 addresses and hosts with a port
+// are quoted.
+func forwardedElement(client, host, proto string) string {
+	if strings.Contains(client, ":") {
+		client = `"[` + client + `]"`
+	}
+	if strings.ContainsAny(host, ":[]") {
+		host = `"` + host + `"`
+	}
+	return "for=" + client + ";host=" + host + ";proto=" + proto
+}
@@ -5,29 +5,19 @@ import (
 	"net/http"
 
 	"github.com/letronghoangminh/reproxy/pkg/interfaces"
+	serverpool "github.com/letronghoangminh/reproxy/pkg/services/proxy/server_pool"
 )
 
-type RetryCount string
-
-const (
-	Count RetryCount = "retry_count"
-)
-
-func AllowRetry(r *http.Request, maxRetries int) bool {
-	if retryCount := r.Context().Value(Count); retryCount != nil {
-		if count, ok := retryCount.(int); ok {
-			return count < maxRetries
-		}
-	}
-	return true
-}
-
 type loadBalancer struct {
 	serverPool interfaces.ServerPool
 }
 
 func (lb *loadBalancer) Serve(w http.ResponseWriter, r *http.Request) {
 	peer := lb.serverPool.GetNextValidPeer(r)
+	if peer == nil && serverpool.HasAttempted(r) {
+		// Every available backend failed this request already, retry one of them.
+		peer = lb.serverPool.GetNextValidPeer(serverpool.WithoutAttempted(r))
+	}
 	if peer != nil {
 		peer.Serve(w, r)
 		return
@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/services/proxy/backend"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+)
+
+const defaultMaxRewriteBodySize = 1 << 20
+
+var defaultRewriteContentTypes = []string{
+	"text/",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"application/xhtml+xml",
+}
+
+// modifyResponse applies the response rewriting and the response header rules of the
+// handler to an upstream response.
+func modifyResponse(resp *http.Response, endpoint *url.URL, reverseProxyConfig *config.ReverseProxyConfig) {
+	if rewrite := reverseProxyConfig.ResponseRewrite; rewrite != nil {
+		prefix := utils.GetRequestInfo(resp.Request.Context()).PathPrefix()
+		if rewrite.Location {
+			rewriteLocation(resp, endpoint, prefix)
+		}
+		if rewrite.CookiePath || rewrite.CookieDomain {
+			rewriteCookies(resp, endpoint, prefix, rewrite)
+		}
+		if len(rewrite.Body) > 0 {
+			rewriteBody(resp, rewrite)
+		}
+	}
+
+	if headers := reverseProxyConfig.ResponseHeaders; headers != nil {
+		for _, header := range headers.Remove {
+			resp.Header.Del(header)
+		}
+		for key, value := range headers.Set {
+			resp.Header.Set(key, replaceResponseValue(resp, endpoint, value))
+		}
+		for key, value := range headers.Add {
+			resp.Header.Add(key, replaceResponseValue(resp, endpoint, value))
+		}
+	}
+}
+
+// replaceResponseValue replaces the upstream and the request placeholders found in
+// value.
+func replaceResponseValue(resp *http.Response, endpoint *url.URL, value string) string {
+	if !strings.Contains(value, "{") {
+		return value
+	}
+
+	placeholders := utils.RequestPlaceholders(resp.Request)
+	latency := time.Since(backend.ServeStart(resp.Request.Context())).Milliseconds()
+	placeholders["{upstream_addr}"] = endpoint.Host
+	placeholders["{upstream_latency}"] = strconv.FormatInt(latency, 10)
+	placeholders["{upstream_status}"] = strconv.Itoa(resp.StatusCode)
+
+	return utils.PlaceholderReplacer(placeholders).Replace(value)
+}
+
+// clientScheme is the scheme of the original request, as described by the
+// X-Forwarded-Proto header set for the upstream request.
+func clientScheme(r *http.Request) string {
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		return proto
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// prefixPath adds the stripped path prefix back in front of an upstream path.
+func prefixPath(prefix, path string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" || !strings.HasPrefix(path, "/") {
+		return path
+	}
+	return prefix + path
+}
+
+// rewriteLocation turns redirects to the upstream or to the client-facing host into
+// redirects to the client-facing host, under the stripped path prefix.
+func rewriteLocation(resp *http.Response, endpoint *url.URL, prefix string) {
+	for _, header := range []string{"Location", "Content-Location"} {
+		value := resp.Header.Get(header)
+		if value == "" {
+			continue
+		}
+
+		location, err := url.Parse(value)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case location.Host == "" && location.Scheme == "":
+			if strings.HasPrefix(location.Path, "/") {
+				location.Path = prefixPath(prefix, location.Path)
+				location.RawPath = ""
+			}
+		case strings.EqualFold(location.Host, endpoint.Host) || strings.EqualFold(location.Host, resp.Request.Host):
+			// The Host header of the client is forwarded, backends commonly build
+			// absolute redirects from it.
+			location.Scheme = clientScheme(resp.Request)
+			location.Host = resp.Request.Host
+			location.Path = prefixPath(prefix, location.Path)
+			location.RawPath = ""
+		default:
+			continue
+		}
+
+		resp.Header.Set(header, location.String())
+	}
+}
+
+// rewriteCookies moves the cookies set by the upstream under the stripped path prefix,
+// and to the client-facing host when their domain is the upstream host.
+func rewriteCookies(resp *http.Response, endpoint *url.URL, prefix string, rewrite *config.ResponseRewriteConfig) {
+	values := resp.Header.Values("Set-Cookie")
+	if len(values) == 0 {
+		return
+	}
+
+	upstreamHost := strings.ToLower(endpoint.Hostname())
+	clientHost := resp.Request.Host
+	if host, _, err := net.SplitHostPort(clientHost); err == nil {
+		clientHost = host
+	}
+
+	rewritten := make([]string, 0, len(values))
+	for _, value := range values {
+		attributes := strings.Split(value, ";")
+		for i, attribute := range attributes[1:] {
+			key, attributeValue, _ := strings.Cut(strings.TrimSpace(attribute), "=")
+			switch {
+			case rewrite.CookiePath && strings.EqualFold(key, "Path"):
+				path := prefixPath(prefix, attributeValue)
+				if attributeValue == "/" && prefix != "" {
+					path = strings.TrimSuffix(prefix, "/")
+				}
+				attributes[i+1] = " Path=" + path
+			case rewrite.CookieDomain && strings.EqualFold(key, "Domain"):
+				if strings.ToLower(strings.TrimPrefix(attributeValue, ".")) == upstreamHost {
+					attributes[i+1] = " Domain=" + clientHost
+				}
+			}
+		}
+		rewritten = append(rewritten, strings.Join(attributes, ";"))
+	}
+
+	resp.Header["Set-Cookie"] = rewritten
+}
+
+// rewriteBody applies the body replacements to text responses. Encoded bodies and
+// bodies larger than max_body_size are passed through unchanged.
+func rewriteBody(resp *http.Response, rewrite *config.ResponseRewriteConfig) {
+	if resp.Body == nil || resp.Body == http.NoBody {
+		return
+	}
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return
+	}
+	if !rewritableContentType(resp.Header.Get("Content-Type"), rewrite.ContentTypes) {
+		return
+	}
+
+	maxBodySize := rewrite.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultMaxRewriteBodySize
+	}
+	if resp.ContentLength > maxBodySize {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil || int64(len(body)) > maxBodySize {
+		if err != nil {
+			utils.Logger.Debug("error reading the response body to rewrite", "error", err)
+		}
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return
+	}
+	_ = resp.Body.Close()
+
+	original := body
+	for _, replacement := range rewrite.Body {
+		body = bytes.ReplaceAll(body, []byte(replacement.Search), []byte(replaceHeaderValue(resp.Request, replacement.Replace)))
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	if !bytes.Equal(original, body) {
+		// The representation changed, a strong validator no longer applies.
+		if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			resp.Header.Set("ETag", "W/"+etag)
+		}
+	}
+}
+
+func rewritableContentType(contentType string, contentTypes []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if len(contentTypes) == 0 {
+		contentTypes = defaultRewriteContentTypes
+	}
+	for _, allowed := range contentTypes {
+		allowed = strings.ToLower(allowed)
+		if mediaType == allowed || (strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediaType, allowed)) {
+			return true
+		}
+	}
+	return false
+}
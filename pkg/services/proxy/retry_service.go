@@ -0,0 +1,319 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	serverpool "github.com/letronghoangminh/reproxy/pkg/services/proxy/server_pool"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+)
+
+const (
+	defaultRetries      = 3
+	defaultBaseBackoff  = 25 * time.Millisecond
+	defaultMaxBackoff   = time.Second
+	defaultMaxRetryBody = 64 * 1024
+
+	// retryBudgetWindow is the number of seconds of traffic the retry budget is
+	// computed over.
+	retryBudgetWindow = 10
+)
+
+// errRetryableStatus is returned by ModifyResponse to discard a response that is
+// going to be retried.
+var errRetryableStatus = errors.New("retryable upstream status")
+
+type statusRange struct {
+	min, max int
+}
+
+// retryPolicy is the compiled retry configuration of a handler.
+type retryPolicy struct {
+	retries      int
+	connectError bool
+	statuses     []statusRange
+	baseBackoff  time.Duration
+	maxBackoff   time.Duration
+	maxBodySize  int64
+}
+
+// newRetryPolicy compiles the retry configuration. Unset retries default to
+// defaultRetries, and 0 disables retries.
+func newRetryPolicy(retries *int, retryConfig *config.RetryConfig) (*retryPolicy, error) {
+	policy := &retryPolicy{
+		retries:      defaultRetries,
+		connectError: true,
+		baseBackoff:  defaultBaseBackoff,
+		maxBackoff:   defaultMaxBackoff,
+		maxBodySize:  defaultMaxRetryBody,
+	}
+	if retries != nil {
+		policy.retries = *retries
+	}
+	if retryConfig == nil {
+		return policy, nil
+	}
+
+	if retryConfig.BaseBackoff > 0 {
+		policy.baseBackoff = time.Duration(retryConfig.BaseBackoff) * time.Millisecond
+	}
+	if retryConfig.MaxBackoff > 0 {
+		policy.maxBackoff = time.Duration(retryConfig.MaxBackoff) * time.Millisecond
+	}
+	policy.maxBackoff = max(policy.maxBackoff, policy.baseBackoff)
+	if retryConfig.MaxBodySize > 0 {
+		policy.maxBodySize = retryConfig.MaxBodySize
+	}
+
+	if len(retryConfig.RetryOn) > 0 {
+		policy.connectError = false
+		for _, condition := range retryConfig.RetryOn {
+			if condition == "connect_error" {
+				policy.connectError = true
+				continue
+			}
+			r, err := parseRetryStatus(condition)
+			if err != nil {
+				return nil, err
+			}
+			policy.statuses = append(policy.statuses, r)
+		}
+	}
+
+	return policy, nil
+}
+
+// parseRetryStatus accepts a status code (503) or a class (5xx).
+func parseRetryStatus(value string) (statusRange, error) {
+	invalid := fmt.Errorf("invalid retry_on condition %q, expected connect_error, a status code or a class such as 5xx", value)
+
+	if class, ok := strings.CutSuffix(strings.ToLower(value), "xx"); ok {
+		digit, err := strconv.Atoi(class)
+		if err != nil || digit < 1 || digit > 5 {
+			return statusRange{}, invalid
+		}
+		return statusRange{min: digit * 100, max: digit*100 + 99}, nil
+	}
+
+	status, err := strconv.Atoi(value)
+	if err != nil || status < 100 || status > 599 {
+		return statusRange{}, invalid
+	}
+	return statusRange{min: status, max: status}, nil
+}
+
+func (p *retryPolicy) retryableStatus(status int) bool {
+	for _, r := range p.statuses {
+		if status >= r.min && status <= r.max {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before the given retry, counted from 0: an exponential
+// backoff capped at max_backoff with full jitter.
+func (p *retryPolicy) backoff(retry int) time.Duration {
+	ceiling := p.maxBackoff
+	if retry < 32 {
+		ceiling = min(p.baseBackoff<<retry, p.maxBackoff)
+	}
+	return rand.N(ceiling) + 1
+}
+
+// attempt carries the retry state of a request through the reverse proxy hooks.
+type attempt struct {
+	ctx        context.Context
+	policy     *retryPolicy
+	replayable bool
+	retries    int
+	retry      bool
+	delay      time.Duration
+	attempted  serverpool.Attempted
+}
+
+type attemptKey struct{}
+
+func getAttempt(ctx context.Context) *attempt {
+	a, _ := ctx.Value(attemptKey{}).(*attempt)
+	return a
+}
+
+// allowRetry decides whether a failed attempt is retried, in which case nothing must
+// be written to the client. A retry needs a replayable request, retries left, time
+// before the client's deadline and room in the retry budget.
+func (a *attempt) allowRetry() bool {
+	if a == nil || !a.replayable || a.retries >= a.policy.retries || a.ctx.Err() != nil {
+		return false
+	}
+
+	delay := a.policy.backoff(a.retries)
+	if deadline, ok := a.ctx.Deadline(); ok && time.Until(deadline) <= delay {
+		return false
+	}
+	if !budget.withdraw() {
+		utils.Logger.Warn("Retry budget exhausted, not retrying")
+		return false
+	}
+
+	a.retry = true
+	a.delay = delay
+	return true
+}
+
+// serveWithRetries sends the request to the load balancer until an attempt succeeds,
+// is not retryable or the retries are exhausted.
+func serveWithRetries(w http.ResponseWriter, r *http.Request, group *upstreamGroup) {
+	budget.deposit()
+
+	a := &attempt{
+		ctx:        r.Context(),
+		policy:     group.retryPolicy,
+		replayable: bufferBody(r, group.retryPolicy),
+	}
+	ctx := serverpool.WithAttempted(context.WithValue(r.Context(), attemptKey{}, a), &a.attempted)
+	r = r.WithContext(ctx)
+
+	for {
+		a.retry = false
+		if r.GetBody != nil {
+			r.Body, _ = r.GetBody()
+		}
+
+		group.loadBalancer.Serve(w, r)
+		if !a.retry {
+			return
+		}
+		a.retries++
+
+		timer := time.NewTimer(a.delay)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+				http.Error(w, "Gateway timeout", http.StatusGatewayTimeout)
+			}
+			return
+		}
+	}
+}
+
+// bufferBody reads the body of an idempotent request into memory so that it can be
+// sent again, and reports whether the request can be retried. Bodies over
+// max_body_size are streamed as they are and the request is not retried.
+func bufferBody(r *http.Request, policy *retryPolicy) bool {
+	if !isIdempotent(r) {
+		return false
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	if r.ContentLength > policy.maxBodySize {
+		return false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, policy.maxBodySize+1))
+	if err != nil || int64(len(body)) > policy.maxBodySize {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return false
+	}
+
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return true
+}
+
+// isIdempotent follows net/http: requests with an idempotent method or an
+// Idempotency-Key header may be sent twice.
+func isIdempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Header.Get("Idempotency-Key") != "" || r.Header.Get("X-Idempotency-Key") != ""
+}
+
+type budgetBucket struct {
+	second   int64
+	requests int
+	retries  int
+}
+
+// retryBudget caps the retries of all handlers to a percentage of the requests of the
+// last retryBudgetWindow seconds, so that retries cannot multiply the load of
+// failing upstreams.
+type retryBudget struct {
+	mux          sync.Mutex
+	percent      int
+	minPerSecond int
+	buckets      [retryBudgetWindow]budgetBucket
+}
+
+var budget = &retryBudget{percent: 20, minPerSecond: 10}
+
+// SetRetryBudget applies the retry_budget block of the global configuration.
+func SetRetryBudget(retryBudgetConfig *config.RetryBudgetConfig) {
+	budget.mux.Lock()
+	defer budget.mux.Unlock()
+
+	budget.percent, budget.minPerSecond = 20, 10
+	if retryBudgetConfig == nil {
+		return
+	}
+	if retryBudgetConfig.Percent > 0 {
+		budget.percent = retryBudgetConfig.Percent
+	}
+	if retryBudgetConfig.MinPerSecond > 0 {
+		budget.minPerSecond = retryBudgetConfig.MinPerSecond
+	}
+}
+
+func (b *retryBudget) deposit() {
+	b.mux.Lock()
+	b.bucket(time.Now().Unix()).requests++
+	b.mux.Unlock()
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	now := time.Now().Unix()
+	requests, retries := 0, 0
+	for _, bucket := range b.buckets {
+		if now-bucket.second < retryBudgetWindow {
+			requests += bucket.requests
+			retries += bucket.retries
+		}
+	}
+
+	if retries >= max(requests*b.percent/100, b.minPerSecond*retryBudgetWindow) {
+		return false
+	}
+	b.bucket(now).retries++
+	return true
+}
+
+// bucket must be called with the lock held.
+func (b *retryBudget) bucket(second int64) *budgetBucket {
+	bucket := &b.buckets[second%retryBudgetWindow]
+	if bucket.second != second {
+		*bucket = budgetBucket{second: second}
+	}
+	return bucket
+}
@@ -3,16 +3,21 @@ package proxy
 
 import (
 	"context"
-	"net"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/letronghoangminh/reproxy/pkg/config"
 	"github.com/letronghoangminh/reproxy/pkg/interfaces"
 	"github.com/letronghoangminh/reproxy/pkg/services/dns"
+	"github.com/letronghoangminh/reproxy/pkg/services/metrics"
 	"github.com/letronghoangminh/reproxy/pkg/services/proxy/backend"
 	loadbalancer "github.com/letronghoangminh/reproxy/pkg/services/proxy/load_balancer"
 	serverpool "github.com/letronghoangminh/reproxy/pkg/services/proxy/server_pool"
@@ -21,105 +26,288 @@ import (
 
 // Reverse proxy implementation credits to https://github.com/leonardo5621/golang-load-balancer
 
+// upstreamGroup ties the load balancer of a handler to its server pool, so that
+// backends can be inspected and changed at runtime through the admin API.
+type upstreamGroup struct {
+	ctx             context.Context
+	handler         *config.HandlerConfig
+	loadBalancer    interfaces.LoadBalancer
+	serverPool      interfaces.ServerPool
+	healthChecker   *serverpool.HealthChecker
+	outlierDetector *serverpool.OutlierDetector
+	retryPolicy     *retryPolicy
+	tlsConfig       *tls.Config
+	transport       *instrumentedTransport
+	transportStats  *transportStats
+	requestTimeout  time.Duration
+}
+
 var (
-	loadBalancers = map[*config.HandlerConfig]interfaces.LoadBalancer{}
+	upstreamGroups         = map[*config.HandlerConfig]*upstreamGroup{}
+	previousUpstreamGroups = map[*config.HandlerConfig]*upstreamGroup{}
+	upstreamGroupsMutex    sync.RWMutex
 )
 
-func StartLoadBalancers(ctx context.Context, handlers []*config.HandlerConfig) {
+// StartLoadBalancers builds the load balancers of the given handlers and replaces the
+// current set once all of them were created. Health checks run until ctx is cancelled,
+// which lets a configuration reload retire the previous generation of server pools.
+func StartLoadBalancers(ctx context.Context, handlers []*config.HandlerConfig) error {
+	newUpstreamGroups := make(map[*config.HandlerConfig]*upstreamGroup, len(handlers))
+
 	for _, handler := range handlers {
-		serverPool, err := serverpool.NewServerPool(serverpool.GetLBStrategy(handler.ReverseProxy.LoadBalancing.Strategy))
+		loadBalancing := handler.ReverseProxy.LoadBalancing
+		serverPool, err := serverpool.NewServerPool(serverpool.GetLBStrategy(loadBalancing.Strategy), loadBalancing.HashKey)
 		if err != nil {
-			utils.Logger.Error("error occurred while creating server pool", "error", err)
-			return
+			return fmt.Errorf("error occurred while creating server pool: %w", err)
+		}
+
+		tlsConfig, err := newUpstreamTLSConfig(handler.ReverseProxy.TLS)
+		if err != nil {
+			return err
+		}
+
+		healthChecker, err := serverpool.NewHealthChecker(handler.ReverseProxy.HealthCheck, tlsConfig)
+		if err != nil {
+			return err
 		}
 
-		loadBalancer := loadbalancer.NewLoadBalancer(serverPool)
+		retryPolicy, err := newRetryPolicy(loadBalancing.Retries, handler.ReverseProxy.Retry)
+		if err != nil {
+			return err
+		}
 
-		staticUpstreams := handler.ReverseProxy.Upstreams.Static
+		group := &upstreamGroup{
+			ctx:             ctx,
+			handler:         handler,
+			loadBalancer:    loadbalancer.NewLoadBalancer(serverPool),
+			serverPool:      serverPool,
+			healthChecker:   healthChecker,
+			outlierDetector: serverpool.NewOutlierDetector(ctx, handler.ReverseProxy.PassiveHealth, serverPool),
+			retryPolicy:     retryPolicy,
+			tlsConfig:       tlsConfig,
+			transportStats:  &transportStats{},
+			requestTimeout:  requestTimeout(handler.ReverseProxy.Timeouts),
+		}
+		if handler.ReverseProxy.Transport == nil || !handler.ReverseProxy.Transport.PerBackend {
+			group.transport = newTransport(handler, group.transportStats, group.tlsConfig)
+			go closeIdleConnections(ctx, group.transport)
+		}
+
+		upstreams := slices.Clone(handler.ReverseProxy.Upstreams.Static)
 		dynamicUpstreams, dnsErr := dns.GetDynamicUpstreams(handler.ReverseProxy.Upstreams.Dynamic)
 		if dnsErr != nil {
 			utils.Logger.Error("error resolving dynamic upstreams", "error", dnsErr)
 			dynamicUpstreams = []string{}
 		}
+		for _, u := range dynamicUpstreams {
+			upstreams = append(upstreams, config.StaticUpstreamConfig{URL: u})
+		}
 
-		for _, u := range append(staticUpstreams, dynamicUpstreams...) {
-			endpoint, err := url.Parse(u)
+		for _, upstream := range upstreams {
+			endpoint, err := url.Parse(upstream.URL)
 			if err != nil {
-				utils.Logger.Fatal(err.Error(), "URL", u)
+				return fmt.Errorf("invalid upstream URL %q: %w", upstream.URL, err)
 			}
 
-			rp := httputil.NewSingleHostReverseProxy(endpoint)
+			serverPool.AddBackend(newBackend(group, endpoint, upstream.Weight))
+		}
 
-			backendServer := backend.NewBackend(endpoint, rp)
-			rp.ErrorHandler = func(writer http.ResponseWriter, request *http.Request, e error) {
-				utils.Logger.Debug("error handling the request",
-					"host", endpoint.Host,
-				)
-				backendServer.SetAlive(false)
+		go serverpool.LaunchHealthCheck(ctx, serverPool, healthChecker)
+
+		newUpstreamGroups[handler] = group
+	}
+
+	// The previous generation stays reachable so that requests matched against the old
+	// handlers right before a reload can still be served.
+	upstreamGroupsMutex.Lock()
+	previousUpstreamGroups = upstreamGroups
+	upstreamGroups = newUpstreamGroups
+	upstreamGroupsMutex.Unlock()
+
+	return nil
+}
+
+// RestoreLoadBalancers switches back to the load balancers replaced by the last call
+// to StartLoadBalancers, when the rest of a reload failed.
+func RestoreLoadBalancers() {
+	upstreamGroupsMutex.Lock()
+	upstreamGroups = previousUpstreamGroups
+	upstreamGroupsMutex.Unlock()
+}
+
+func newBackend(group *upstreamGroup, endpoint *url.URL, weight int) interfaces.Backend {
+	handler := group.handler
+
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			rewriteRequest(pr, endpoint)
+		},
+	}
+	rp.FlushInterval = flushInterval(handler.ReverseProxy.Transport)
+
+	// With per_backend every backend gets its own connection pool, which still counts
+	// towards the statistics of the handler.
+	transport := group.transport
+	if transport == nil {
+		transport = newTransport(handler, group.transportStats, group.tlsConfig)
+		go closeIdleConnections(group.ctx, transport)
+	}
+	rp.Transport = transport
+
+	backendServer := backend.NewBackend(endpoint, weight, rp, handler.ReverseProxy.CircuitBreaker)
+
+	rp.ModifyResponse = func(resp *http.Response) error {
+		resp.Header.Set("X-Powered-By", "Reproxy")
+		backendServer.RecordResult(resp.Request.Context(), resp.StatusCode < http.StatusInternalServerError)
+		if group.outlierDetector != nil {
+			latency := time.Since(backend.ServeStart(resp.Request.Context()))
+			group.outlierDetector.ObserveResponse(backendServer, resp.StatusCode, latency)
+		}
+
+		a := getAttempt(resp.Request.Context())
+		if a != nil && a.policy.retryableStatus(resp.StatusCode) && a.allowRetry() {
+			a.attempted.Add(backendServer)
+			logRetry(resp.Request, endpoint, a, fmt.Sprintf("status %d", resp.StatusCode))
+			return errRetryableStatus
+		}
+
+		modifyResponse(resp, endpoint, &handler.ReverseProxy)
+		backend.SetCookies(resp.Request.Context(), resp.Header)
+		return nil
+	}
 
-				if !loadbalancer.AllowRetry(request, handler.ReverseProxy.LoadBalancing.Retries) {
-					utils.Logger.Info(
-						"Max retry attempts reached, terminating",
-						"address", request.RemoteAddr,
-						"path", request.URL.Path,
-					)
-					http.Error(writer, "Service not available", http.StatusServiceUnavailable)
-					return
-				}
-
-				currentCount, ok := request.Context().Value(loadbalancer.Count).(int)
-				if !ok {
-					currentCount = 0
-				}
-
-				utils.Logger.Info(
-					"Attempting retry",
-					"address", request.RemoteAddr,
-					"URL", request.URL.Path,
-					"retry", true,
-					"retry_count", currentCount+1,
-				)
-
-				sleepDuration := handler.ReverseProxy.LoadBalancing.TryInterval
-				if sleepDuration == 0 {
-					sleepDuration = 5
-				}
-				time.Sleep(time.Duration(sleepDuration) * time.Second)
-
-				loadBalancer.Serve(
-					writer,
-					request.WithContext(
-						context.WithValue(request.Context(), loadbalancer.Count, currentCount+1),
-					),
-				)
+	rp.ErrorHandler = func(writer http.ResponseWriter, request *http.Request, e error) {
+		// The response was discarded by ModifyResponse and the request is retried.
+		if errors.Is(e, errRetryableStatus) {
+			return
+		}
+
+		utils.Logger.Debug("error handling the request",
+			"host", endpoint.Host,
+			"error", e,
+		)
+
+		// With passive health checking a backend is ejected after several failures
+		// instead of being marked down until the next active check. Requests cancelled
+		// by the client or past their deadline say nothing about the backend.
+		if request.Context().Err() == nil {
+			if group.outlierDetector == nil {
+				backendServer.SetAlive(false)
+			} else {
+				group.outlierDetector.ObserveFailure(backendServer)
 			}
+			backendServer.RecordResult(request.Context(), false)
+		}
+
+		a := getAttempt(request.Context())
+		if a != nil && a.policy.connectError && a.allowRetry() {
+			a.attempted.Add(backendServer)
+			logRetry(request, endpoint, a, e.Error())
+			return
+		}
 
-			serverPool.AddBackend(backendServer)
+		utils.Logger.Info(
+			"Upstream request failed, terminating",
+			"address", request.RemoteAddr,
+			"path", request.URL.Path,
+		)
+		if isTimeout(request.Context(), e) {
+			http.Error(writer, "Gateway timeout", http.StatusGatewayTimeout)
+			return
 		}
+		http.Error(writer, "Service not available", http.StatusServiceUnavailable)
+	}
 
-		go serverpool.LaunchHealthCheck(ctx, serverPool)
+	return backendServer
+}
+
+func logRetry(r *http.Request, endpoint *url.URL, a *attempt, reason string) {
+	metrics.ObserveRetry(endpoint.Host)
+	utils.Logger.Info(
+		"Attempting retry",
+		"address", r.RemoteAddr,
+		"URL", r.URL.Path,
+		"upstream", endpoint.Host,
+		"reason", reason,
+		"retry_count", a.retries+1,
+		"delay", a.delay.String(),
+	)
+}
 
-		loadBalancers[handler] = loadBalancer
+// closeIdleConnections releases the idle connections of a transport once its
+// generation is retired.
+func closeIdleConnections(ctx context.Context, transport *instrumentedTransport) {
+	<-ctx.Done()
+	transport.CloseIdleConnections()
+}
+
+func getUpstreamGroup(handler *config.HandlerConfig) *upstreamGroup {
+	upstreamGroupsMutex.RLock()
+	defer upstreamGroupsMutex.RUnlock()
+
+	group, ok := upstreamGroups[handler]
+	if !ok {
+		group = previousUpstreamGroups[handler]
 	}
+	return group
 }
 
 func HandleReverseProxyRequest(w http.ResponseWriter, r *http.Request, handler *config.HandlerConfig) {
-	loadBalancer := loadBalancers[handler]
-	if loadBalancer == nil {
+	group := getUpstreamGroup(handler)
+	if group == nil {
 		http.Error(w, "Load balancer not found", http.StatusInternalServerError)
 		return
 	}
 
+	applyForwarding(r, handler.ReverseProxy.Forwarding)
 	addHeaders(r, handler.ReverseProxy.AddHeaders)
 	removeHeaders(r, handler.ReverseProxy.RemoveHeaders)
 
-	if handler.Matchers.Path != "" {
-		r.URL.Path = strings.TrimPrefix(r.URL.Path, handler.Matchers.Path)
+	// Bodies are rewritten uncompressed, the response is still compressed by the
+	// listener when the client accepts it.
+	if rewrite := handler.ReverseProxy.ResponseRewrite; rewrite != nil && len(rewrite.Body) > 0 {
+		r.Header.Del("Accept-Encoding")
 	}
 
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, utils.GetRequestInfo(r.Context()).PathPrefix())
+
 	rewritePath(r, handler.ReverseProxy.Rewrite)
 
-	loadBalancer.Serve(w, r)
+	// Upgraded connections are bounded by the tunnel idle timeout instead of the
+	// request deadline.
+	if utils.IsUpgradeRequest(r) {
+		upgradeWriter := &upgradeResponseWriter{
+			ResponseWriter: w,
+			idleTimeout:    tunnelIdleTimeout(handler.ReverseProxy.TunnelIdleTimeout),
+		}
+		defer upgradeWriter.finish()
+		w = upgradeWriter
+	} else if group.requestTimeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), group.requestTimeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	serveWithRetries(w, r, group)
+}
+
+// rewriteRequest sends the request to the backend with the Host header of the client.
+// The forwarding headers were already set by applyForwarding, the reverse proxy does
+// not add its own.
+func rewriteRequest(pr *httputil.ProxyRequest, endpoint *url.URL) {
+	pr.SetURL(endpoint)
+	pr.Out.Host = pr.In.Host
+
+	for _, header := range forwardingHeaders {
+		if values := pr.In.Header.Values(header); len(values) > 0 {
+			pr.Out.Header[header] = slices.Clone(values)
+		}
+	}
+
+	// Keep Go from sending its default User-Agent.
+	if _, ok := pr.Out.Header["User-Agent"]; !ok {
+		pr.Out.Header.Set("User-Agent", "")
+	}
 }
 
 func removeHeaders(r *http.Request, headers []string) {
@@ -129,40 +317,17 @@ func removeHeaders(r *http.Request, headers []string) {
 }
 
 func addHeaders(r *http.Request, headers map[string]string) {
-	r.Header.Add("X-Forwarded-For", r.RemoteAddr)
-	r.Header.Add("X-Forwarded-Host", r.Host)
-	r.Header.Add("X-Forwarded-Proto", r.URL.Scheme)
-
 	for key, value := range headers {
 		r.Header.Add(key, replaceHeaderValue(r, value))
 	}
 }
 
 func replaceHeaderValue(r *http.Request, value string) string {
-	replacements := map[string]string{
-		"{remote_ip}": func() string {
-			host, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err != nil {
-				return r.RemoteAddr
-			}
-			return host
-		}(),
-		"{scheme}":     r.URL.Scheme,
-		"{host}":       r.Host,
-		"{path}":       r.URL.Path,
-		"{query}":      r.URL.RawQuery,
-		"{method}":     r.Method,
-		"{user_agent}": r.UserAgent(),
-	}
-
-	result := value
-	for placeholder, replacement := range replacements {
-		result = strings.ReplaceAll(result, placeholder, replacement)
-	}
-
-	return result
+	return utils.ReplacePlaceholders(r, value)
 }
 
+// rewritePath replaces the path with the rewrite pattern, in which {path} is the path
+// left after stripping the matched prefix and {name} a capture of the path matcher.
 func rewritePath(r *http.Request, rewrite string) {
 	if rewrite == "" {
 		return
@@ -170,7 +335,7 @@ func rewritePath(r *http.Request, rewrite string) {
 
 	rewrite = strings.TrimPrefix(rewrite, "/")
 	rewrite = strings.TrimSuffix(rewrite, "/")
-	newPath := strings.ReplaceAll(rewrite, "{path}", r.URL.Path)
+	newPath := utils.ReplacePlaceholders(r, rewrite)
 
 	r.URL.Path = newPath
 }
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+)
+
+func newTestGroup(t *testing.T) *upstreamGroup {
+	t.Helper()
+
+	utils.GetLogger()
+	handler := &config.HandlerConfig{}
+	group := &upstreamGroup{ctx: t.Context(), handler: handler, transportStats: &transportStats{}}
+	group.transport = newTransport(handler, group.transportStats, nil)
+	return group
+}
+
+func TestErrorHandlerKeepsBackendOfCancelledRequests(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+	endpoint, _ := url.Parse(upstream.URL)
+
+	tests := []struct {
+		name    string
+		context func() (context.Context, context.CancelFunc)
+	}{
+		{name: "cancelled by the client", context: func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			return ctx, cancel
+		}},
+		{name: "past the request deadline", context: func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 50*time.Millisecond)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendServer := newBackend(newTestGroup(t), endpoint, 1)
+			backendServer.SetAlive(true)
+
+			ctx, cancel := tt.context()
+			defer cancel()
+			backendServer.Serve(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+
+			if !backendServer.IsAlive() {
+				t.Error("a backend must not be marked down for a request that was cancelled")
+			}
+		})
+	}
+}
+
+func TestErrorHandlerMarksUnreachableBackendDown(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	endpoint, _ := url.Parse(upstream.URL)
+	upstream.Close()
+
+	backendServer := newBackend(newTestGroup(t), endpoint, 1)
+	backendServer.SetAlive(true)
+	backendServer.Serve(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if backendServer.IsAlive() {
+		t.Error("an unreachable backend must be marked down without passive health checks")
+	}
+}
+
+func TestRetrySetsCookiesOfAnsweringBackend(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	answering := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer answering.Close()
+
+	group := newTestGroup(t)
+	policy, err := newRetryPolicy(nil, &config.RetryConfig{RetryOn: []string{"503"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &attempt{ctx: context.Background(), policy: policy, replayable: true}
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), attemptKey{}, a))
+	w := httptest.NewRecorder()
+
+	for _, upstream := range []*httptest.Server{failing, answering} {
+		endpoint, _ := url.Parse(upstream.URL)
+		backendServer := newBackend(group, endpoint, 1)
+		backendServer.AddCookie(&http.Cookie{Name: "X-Sticky-Session-ID", Value: endpoint.Host})
+		backendServer.Serve(w, r)
+	}
+
+	if !a.retry {
+		t.Fatal("expected the 503 of the first backend to be retried")
+	}
+	cookies := w.Result().Cookies()
+	answeringURL, _ := url.Parse(answering.URL)
+	if len(cookies) != 1 || cookies[0].Value != answeringURL.Host {
+		t.Errorf("Set-Cookie = %v, want only the cookie of %s", w.Header().Values("Set-Cookie"), answeringURL.Host)
+	}
+}
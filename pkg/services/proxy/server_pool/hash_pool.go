@@ -0,0 +1,151 @@
+package serverpool
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/letronghoangminh/reproxy/pkg/interfaces"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+)
+
+// virtualNodes is the number of points each unit of weight puts on the ring. More
+// points spread keys more evenly at the cost of a larger ring.
+const virtualNodes = 160
+
+type ringNode struct {
+	hash    uint64
+	backend interfaces.Backend
+}
+
+// hashServerPool places backends on a consistent hash ring, so that adding or removing
+// a backend only remaps the keys next to it. A key is served by the first available
+// backend clockwise from its hash, which moves it to a neighbour while its own backend
+// is down and back once it recovers.
+type hashServerPool struct {
+	backends []interfaces.Backend
+	ring     []ringNode
+	key      func(r *http.Request) string
+	mux      sync.RWMutex
+}
+
+func newHashServerPool(hashKey string) (*hashServerPool, error) {
+	key, err := hashKeyFunc(hashKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hashServerPool{
+		backends: make([]interfaces.Backend, 0),
+		key:      key,
+	}, nil
+}
+
+// hashKeyFunc returns the function extracting the hashed value of a request, which is
+// one of remote_ip, path, header:<name>, cookie:<name> or query:<name>. Requests that
+// do not carry the header, cookie or query parameter are hashed by client IP.
+func hashKeyFunc(hashKey string) (func(r *http.Request) string, error) {
+	kind, name, _ := strings.Cut(hashKey, ":")
+
+	switch kind {
+	case "remote_ip":
+		return utils.RemoteIP, nil
+	case "path":
+		return func(r *http.Request) string { return r.URL.Path }, nil
+	}
+
+	if name == "" {
+		return nil, fmt.Errorf("invalid hash key %q, expected remote_ip, path, header:<name>, cookie:<name> or query:<name>", hashKey)
+	}
+
+	var value func(r *http.Request) string
+	switch kind {
+	case "header":
+		value = func(r *http.Request) string { return r.Header.Get(name) }
+	case "cookie":
+		value = func(r *http.Request) string {
+			cookie, err := r.Cookie(name)
+			if err != nil {
+				return ""
+			}
+			return cookie.Value
+		}
+	case "query":
+		value = func(r *http.Request) string { return r.URL.Query().Get(name) }
+	default:
+		return nil, fmt.Errorf("invalid hash key %q, expected remote_ip, path, header:<name>, cookie:<name> or query:<name>", hashKey)
+	}
+
+	return func(r *http.Request) string {
+		if v := value(r); v != "" {
+			return v
+		}
+		return utils.RemoteIP(r)
+	}, nil
+}
+
+func (s *hashServerPool) GetNextValidPeer(r *http.Request) interfaces.Backend {
+	hash := xxhash.Sum64String(s.key(r))
+
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	if len(s.ring) == 0 {
+		return nil
+	}
+
+	start := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= hash })
+	for i := 0; i < len(s.ring); i++ {
+		b := s.ring[(start+i)%len(s.ring)].backend
+		if isCandidate(r, b) {
+			return b
+		}
+	}
+	return nil
+}
+
+func (s *hashServerPool) AddBackend(b interfaces.Backend) {
+	s.mux.Lock()
+	s.backends = append(s.backends, b)
+	s.buildRing()
+	s.mux.Unlock()
+}
+
+func (s *hashServerPool) RemoveBackend(b interfaces.Backend) {
+	s.mux.Lock()
+	s.backends = removeBackend(s.backends, b)
+	s.buildRing()
+	s.mux.Unlock()
+}
+
+// buildRing must be called with the lock held. Points are derived from the backend
+// URL, so every instance of reproxy builds the same ring for the same backends.
+func (s *hashServerPool) buildRing() {
+	ring := make([]ringNode, 0, len(s.backends)*virtualNodes)
+	for _, b := range s.backends {
+		for i := 0; i < b.GetWeight()*virtualNodes; i++ {
+			ring = append(ring, ringNode{
+				hash:    xxhash.Sum64String(b.GetURL().String() + "#" + strconv.Itoa(i)),
+				backend: b,
+			})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	s.ring = ring
+}
+
+func (s *hashServerPool) GetServerPoolSize() int {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return len(s.backends)
+}
+
+func (s *hashServerPool) GetBackends() []interfaces.Backend {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.backends
+}
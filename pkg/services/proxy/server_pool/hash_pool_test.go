@@ -0,0 +1,200 @@
+package serverpool
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/letronghoangminh/reproxy/pkg/interfaces"
+)
+
+const hashTestKeys = 30000
+
+func hashRequest(key string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Key", key)
+	return r
+}
+
+// assignments returns the host serving every test key.
+func assignments(pool interfaces.ServerPool) []string {
+	hosts := make([]string, hashTestKeys)
+	for i := range hosts {
+		hosts[i] = backendHost(pool.GetNextValidPeer(hashRequest(fmt.Sprintf("key-%d", i))))
+	}
+	return hosts
+}
+
+func countHosts(hosts []string) map[string]int {
+	counts := map[string]int{}
+	for _, host := range hosts {
+		counts[host]++
+	}
+	return counts
+}
+
+func TestHashPoolDistribution(t *testing.T) {
+	pool := newTestPool(t, ConsistentHash, "header:X-Key",
+		newTestBackend("http://a", 1),
+		newTestBackend("http://b", 1),
+		newTestBackend("http://c", 1),
+	)
+
+	counts := countHosts(assignments(pool))
+	for _, host := range []string{"a", "b", "c"} {
+		share := float64(counts[host]) / hashTestKeys
+		if share < 0.25 || share > 0.42 {
+			t.Errorf("backend %s serves %.2f of the keys, want about a third", host, share)
+		}
+	}
+}
+
+func TestHashPoolWeights(t *testing.T) {
+	pool := newTestPool(t, ConsistentHash, "header:X-Key",
+		newTestBackend("http://a", 1),
+		newTestBackend("http://b", 2),
+	)
+
+	counts := countHosts(assignments(pool))
+	ratio := float64(counts["b"]) / float64(counts["a"])
+	if ratio < 1.6 || ratio > 2.4 {
+		t.Errorf("backend of weight 2 serves %.2f times the keys of weight 1, want about 2", ratio)
+	}
+}
+
+func TestHashPoolMinimalRemapping(t *testing.T) {
+	a, b, c := newTestBackend("http://a", 1), newTestBackend("http://b", 1), newTestBackend("http://c", 1)
+	pool := newTestPool(t, ConsistentHash, "header:X-Key", a, b, c)
+	before := assignments(pool)
+
+	d := newTestBackend("http://d", 1)
+	pool.AddBackend(d)
+	added := assignments(pool)
+
+	moved := 0
+	for i := range before {
+		if before[i] == added[i] {
+			continue
+		}
+		moved++
+		if added[i] != "d" {
+			t.Fatalf("key %d moved from %s to %s, keys may only move to the new backend", i, before[i], added[i])
+		}
+	}
+	if share := float64(moved) / hashTestKeys; share < 0.15 || share > 0.35 {
+		t.Errorf("adding a fourth backend moved %.2f of the keys, want about a quarter", share)
+	}
+
+	pool.RemoveBackend(b)
+	removed := assignments(pool)
+	for i := range added {
+		if added[i] != "b" && removed[i] != added[i] {
+			t.Fatalf("key %d moved from %s to %s although its backend was not removed", i, added[i], removed[i])
+		}
+		if removed[i] == "b" {
+			t.Fatalf("key %d is still served by the removed backend", i)
+		}
+	}
+}
+
+func TestHashPoolSkipsUnavailableBackends(t *testing.T) {
+	a, b, c := newTestBackend("http://a", 1), newTestBackend("http://b", 1), newTestBackend("http://c", 1)
+	pool := newTestPool(t, ConsistentHash, "header:X-Key", a, b, c)
+	before := assignments(pool)
+
+	b.SetEjected(true)
+	ejected := assignments(pool)
+	for i := range before {
+		switch {
+		case ejected[i] == "b":
+			t.Fatalf("key %d is served by an ejected backend", i)
+		case before[i] != "b" && ejected[i] != before[i]:
+			t.Fatalf("key %d moved from %s to %s although its backend is available", i, before[i], ejected[i])
+		}
+	}
+
+	b.SetEjected(false)
+	recovered := assignments(pool)
+	for i := range before {
+		if recovered[i] != before[i] {
+			t.Fatalf("key %d did not move back to %s once it recovered", i, before[i])
+		}
+	}
+
+	a.SetAlive(false)
+	b.SetAlive(false)
+	c.SetAlive(false)
+	if peer := pool.GetNextValidPeer(hashRequest("key")); peer != nil {
+		t.Errorf("expected no peer when every backend is down, got %s", backendHost(peer))
+	}
+}
+
+func TestHashPoolSkipsAttemptedBackends(t *testing.T) {
+	pool := newTestPool(t, ConsistentHash, "header:X-Key",
+		newTestBackend("http://a", 1),
+		newTestBackend("http://b", 1),
+	)
+
+	attempted := &Attempted{}
+	r := hashRequest("key").WithContext(WithAttempted(context.Background(), attempted))
+	first := pool.GetNextValidPeer(r)
+	attempted.Add(first)
+
+	if retry := pool.GetNextValidPeer(r); retry == nil || retry == first {
+		t.Errorf("retry went to %s, want the other backend", backendHost(retry))
+	}
+	attempted.Add(pool.GetNextValidPeer(r))
+	if retry := pool.GetNextValidPeer(r); retry != nil {
+		t.Errorf("expected no peer once every backend was attempted, got %s", backendHost(retry))
+	}
+}
+
+func TestHashKeyFunc(t *testing.T) {
+	tests := []struct {
+		hashKey string
+		target  string
+		header  string
+		cookie  string
+		want    string
+		wantErr bool
+	}{
+		{hashKey: "remote_ip", target: "/a", want: "192.0.2.1"},
+		{hashKey: "path", target: "/a?b=c", want: "/a"},
+		{hashKey: "header:X-User", target: "/", header: "alice", want: "alice"},
+		{hashKey: "header:X-User", target: "/", want: "192.0.2.1"},
+		{hashKey: "cookie:session", target: "/", cookie: "s1", want: "s1"},
+		{hashKey: "query:user", target: "/?user=bob", want: "bob"},
+		{hashKey: "query:user", target: "/", want: "192.0.2.1"},
+		{hashKey: "header", wantErr: true},
+		{hashKey: "body:x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hashKey+tt.target, func(t *testing.T) {
+			key, err := hashKeyFunc(tt.hashKey)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest("GET", tt.target, nil)
+			r.RemoteAddr = "192.0.2.1:4000"
+			if tt.header != "" {
+				r.Header.Set("X-User", tt.header)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "session", Value: tt.cookie})
+			}
+			if got := key(r); got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,22 +2,308 @@ package serverpool
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/letronghoangminh/reproxy/pkg/config"
 	"github.com/letronghoangminh/reproxy/pkg/interfaces"
+	"github.com/letronghoangminh/reproxy/pkg/services/metrics"
+	"github.com/letronghoangminh/reproxy/pkg/services/proxy/backend"
 	"github.com/letronghoangminh/reproxy/pkg/utils"
 )
 
-func LaunchHealthCheck(ctx context.Context, sp interfaces.ServerPool) {
-	t := time.NewTicker(time.Second * 20)
+const (
+	defaultHealthCheckInterval = 20 * time.Second
+	defaultHealthCheckTimeout  = 10 * time.Second
+
+	// maxHealthCheckBody bounds how much of a response is matched against body_regex.
+	maxHealthCheckBody = 64 * 1024
+)
+
+type statusRange struct {
+	min, max int
+}
+
+// HealthChecker probes the backends of a server pool. A backend changes state only
+// after rise consecutive successful or fall consecutive failed checks.
+type HealthChecker struct {
+	interval  time.Duration
+	timeout   time.Duration
+	method    string
+	path      string
+	port      int
+	headers   map[string]string
+	statuses  []statusRange
+	bodyRegex *regexp.Regexp
+	tlsConfig *tls.Config
+	rise      int
+	fall      int
+	client    *http.Client
+
+	mux     sync.Mutex
+	streaks map[interfaces.Backend]int
+}
+
+// NewHealthChecker compiles a health_check block. A nil configuration gives the
+// default TCP check every 20 seconds. tlsConfig is the upstream TLS configuration of
+// the handler, so that checks of https upstreams match real traffic.
+func NewHealthChecker(healthCheckConfig *config.HealthCheckConfig, tlsConfig *tls.Config) (*HealthChecker, error) {
+	hc := &HealthChecker{
+		tlsConfig: tlsConfig,
+		interval:  defaultHealthCheckInterval,
+		timeout:   defaultHealthCheckTimeout,
+		method:    http.MethodGet,
+		statuses:  []statusRange{{min: 200, max: 399}},
+		rise:      1,
+		fall:      1,
+		streaks:   map[interfaces.Backend]int{},
+		client:    newHealthCheckClient(tlsConfig),
+	}
+	if healthCheckConfig == nil {
+		return hc, nil
+	}
+
+	if healthCheckConfig.Interval > 0 {
+		hc.interval = time.Duration(healthCheckConfig.Interval) * time.Second
+	}
+	if healthCheckConfig.Timeout > 0 {
+		hc.timeout = time.Duration(healthCheckConfig.Timeout) * time.Second
+	}
+	if healthCheckConfig.Method != "" {
+		hc.method = healthCheckConfig.Method
+	}
+	if healthCheckConfig.Rise > 0 {
+		hc.rise = healthCheckConfig.Rise
+	}
+	if healthCheckConfig.Fall > 0 {
+		hc.fall = healthCheckConfig.Fall
+	}
+
+	hc.path = healthCheckConfig.Path
+	hc.port = healthCheckConfig.Port
+	hc.headers = healthCheckConfig.Headers
+
+	if len(healthCheckConfig.ExpectedStatus) > 0 {
+		hc.statuses = make([]statusRange, 0, len(healthCheckConfig.ExpectedStatus))
+		for _, status := range healthCheckConfig.ExpectedStatus {
+			r, err := parseStatusRange(status)
+			if err != nil {
+				return nil, err
+			}
+			hc.statuses = append(hc.statuses, r)
+		}
+	}
+
+	if healthCheckConfig.BodyRegex != "" {
+		bodyRegex, err := regexp.Compile(healthCheckConfig.BodyRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid health check body_regex: %w", err)
+		}
+		hc.bodyRegex = bodyRegex
+	}
+
+	return hc, nil
+}
+
+func newHealthCheckClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:             http.ProxyFromEnvironment,
+			TLSClientConfig:   tlsConfig.Clone(),
+			DisableKeepAlives: true,
+		},
+		// Redirects are reported as they are, so that 3xx can be expected or not.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// parseStatusRange accepts a status code (200), a range (200-299) or a class (2xx).
+func parseStatusRange(value string) (statusRange, error) {
+	invalid := fmt.Errorf("invalid health check expected_status %q, expected e.g. 200, 200-299 or 2xx", value)
+
+	if class, ok := strings.CutSuffix(strings.ToLower(value), "xx"); ok {
+		digit, err := strconv.Atoi(class)
+		if err != nil || digit < 1 || digit > 5 {
+			return statusRange{}, invalid
+		}
+		return statusRange{min: digit * 100, max: digit*100 + 99}, nil
+	}
+
+	low, high, isRange := strings.Cut(value, "-")
+	if !isRange {
+		high = low
+	}
+
+	minStatus, err := strconv.Atoi(strings.TrimSpace(low))
+	if err != nil {
+		return statusRange{}, invalid
+	}
+	maxStatus, err := strconv.Atoi(strings.TrimSpace(high))
+	if err != nil || minStatus > maxStatus {
+		return statusRange{}, invalid
+	}
+
+	return statusRange{min: minStatus, max: maxStatus}, nil
+}
+
+func LaunchHealthCheck(ctx context.Context, sp interfaces.ServerPool, hc *HealthChecker) {
+	t := time.NewTicker(hc.interval)
+	defer t.Stop()
+
 	utils.Logger.Info("Starting health check...")
 	for {
 		select {
 		case <-t.C:
-			go HealthCheck(ctx, sp)
+			go HealthCheck(ctx, sp, hc)
 		case <-ctx.Done():
 			utils.Logger.Info("Closing Health Check")
 			return
 		}
 	}
 }
+
+// HealthCheck probes every backend of the pool concurrently and waits for the results.
+func HealthCheck(ctx context.Context, sp interfaces.ServerPool, hc *HealthChecker) {
+	backends := sp.GetBackends()
+
+	wg := sync.WaitGroup{}
+	for _, b := range backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			requestCtx, stop := context.WithTimeout(ctx, hc.timeout)
+			defer stop()
+
+			healthy, reason := hc.probe(requestCtx, b)
+			if ctx.Err() != nil {
+				return
+			}
+
+			metrics.ObserveHealthCheck(b.GetURL().Host, healthy)
+			hc.record(b, healthy, reason)
+		}()
+	}
+	wg.Wait()
+
+	hc.prune(backends)
+}
+
+func (hc *HealthChecker) probe(ctx context.Context, b interfaces.Backend) (bool, string) {
+	target := *b.GetURL()
+	if hc.port != 0 {
+		target.Host = net.JoinHostPort(target.Hostname(), strconv.Itoa(hc.port))
+	}
+
+	if hc.path == "" {
+		aliveChannel := make(chan bool, 1)
+		backend.IsBackendAlive(ctx, aliveChannel, &target, hc.tlsConfig)
+		if !<-aliveChannel {
+			return false, "connection failed"
+		}
+		return true, ""
+	}
+
+	target.Path = hc.path
+	target.RawQuery = ""
+	if path, query, ok := strings.Cut(hc.path, "?"); ok {
+		target.Path, target.RawQuery = path, query
+	}
+
+	req, err := http.NewRequestWithContext(ctx, hc.method, target.String(), nil)
+	if err != nil {
+		return false, err.Error()
+	}
+	req.Header.Set("User-Agent", "reproxy-health-check")
+	for key, value := range hc.headers {
+		req.Header.Set(key, value)
+	}
+	if host, ok := hc.headers["Host"]; ok {
+		req.Host = host
+	}
+
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		return false, err.Error()
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if !hc.expectedStatus(resp.StatusCode) {
+		return false, fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+
+	if hc.bodyRegex != nil {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthCheckBody))
+		if err != nil {
+			return false, err.Error()
+		}
+		if !hc.bodyRegex.Match(body) {
+			return false, "body does not match body_regex"
+		}
+	}
+
+	return true, ""
+}
+
+func (hc *HealthChecker) expectedStatus(status int) bool {
+	for _, r := range hc.statuses {
+		if status >= r.min && status <= r.max {
+			return true
+		}
+	}
+	return false
+}
+
+// record counts consecutive results, positive for successes and negative for failures,
+// and flips the backend once a threshold is reached.
+func (hc *HealthChecker) record(b interfaces.Backend, healthy bool, reason string) {
+	hc.mux.Lock()
+	streak := hc.streaks[b]
+	if healthy {
+		streak = max(streak, 0) + 1
+	} else {
+		streak = min(streak, 0) - 1
+	}
+	hc.streaks[b] = streak
+	hc.mux.Unlock()
+
+	url := b.GetURL().String()
+	utils.Logger.Debug("URL Status", "URL", url, "healthy", healthy, "reason", reason)
+
+	switch {
+	case healthy && !b.IsAlive() && streak >= hc.rise:
+		b.SetAlive(true)
+		utils.Logger.Info("Backend is up", "URL", url, "successes", streak)
+	case !healthy && b.IsAlive() && -streak >= hc.fall:
+		b.SetAlive(false)
+		utils.Logger.Warn("Backend is down", "URL", url, "failures", -streak, "reason", reason)
+	}
+}
+
+// prune forgets the streaks of backends removed from the pool.
+func (hc *HealthChecker) prune(backends []interfaces.Backend) {
+	current := make(map[interfaces.Backend]bool, len(backends))
+	for _, b := range backends {
+		current[b] = true
+	}
+
+	hc.mux.Lock()
+	for b := range hc.streaks {
+		if !current[b] {
+			delete(hc.streaks, b)
+		}
+	}
+	hc.mux.Unlock()
+}
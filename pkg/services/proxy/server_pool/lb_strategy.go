@@ -9,18 +9,27 @@ const (
 	IPHash
 	URIHash
 	Sticky
+	WeightedRoundRobin
+	WeightedLeastConnections
+	ConsistentHash
 )
 
 func GetLBStrategy(strategy string) LBStrategy {
 	switch strategy {
 	case "least_conn":
 		return LeastConnections
+	case "weighted_round_robin":
+		return WeightedRoundRobin
+	case "weighted_least_conn":
+		return WeightedLeastConnections
 	case "random":
 		return Random
 	case "ip_hash":
 		return IPHash
 	case "uri_hash":
 		return URIHash
+	case "consistent_hash":
+		return ConsistentHash
 	case "sticky":
 		return Sticky
 	default:
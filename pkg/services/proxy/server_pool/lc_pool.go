@@ -18,14 +18,14 @@ func (s *lcServerPool) GetNextValidPeer(r *http.Request) interfaces.Backend {
 
 	var leastConnectedPeer interfaces.Backend
 	for _, b := range s.backends {
-		if b.IsAlive() {
+		if isCandidate(r, b) {
 			leastConnectedPeer = b
 			break
 		}
 	}
 
 	for _, b := range s.backends {
-		if !b.IsAlive() {
+		if !isCandidate(r, b) {
 			continue
 		}
 		if leastConnectedPeer.GetActiveConnections() > b.GetActiveConnections() {
@@ -36,13 +36,25 @@ func (s *lcServerPool) GetNextValidPeer(r *http.Request) interfaces.Backend {
 }
 
 func (s *lcServerPool) AddBackend(b interfaces.Backend) {
+	s.mux.Lock()
 	s.backends = append(s.backends, b)
+	s.mux.Unlock()
+}
+
+func (s *lcServerPool) RemoveBackend(b interfaces.Backend) {
+	s.mux.Lock()
+	s.backends = removeBackend(s.backends, b)
+	s.mux.Unlock()
 }
 
 func (s *lcServerPool) GetServerPoolSize() int {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
 	return len(s.backends)
 }
 
 func (s *lcServerPool) GetBackends() []interfaces.Backend {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
 	return s.backends
 }
@@ -0,0 +1,217 @@
+package serverpool
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/interfaces"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+)
+
+// latencyWeight is the weight of the newest sample in the latency moving average.
+const latencyWeight = 0.1
+
+type outlierStats struct {
+	consecutive5xx      int
+	consecutiveFailures int
+	latency             float64
+	samples             int
+	ejections           int
+	readmittedAt        time.Time
+	readmission         *time.Timer
+}
+
+// OutlierDetector ejects backends from a server pool based on live traffic: consecutive
+// 5xx responses, consecutive connection failures, or a latency that is latency_factor
+// times the median of the pool.
+type OutlierDetector struct {
+	consecutive5xx      int
+	consecutiveFailures int
+	latencyFactor       float64
+	minRequests         int
+	baseEjectionTime    time.Duration
+	maxEjectionTime     time.Duration
+	maxEjectionPercent  int
+
+	ctx        context.Context
+	serverPool interfaces.ServerPool
+	mux        sync.Mutex
+	stats      map[interfaces.Backend]*outlierStats
+}
+
+// NewOutlierDetector returns nil when passive health checking is not configured; all
+// methods accept a nil detector. Once ctx is cancelled backends are no longer ejected
+// and pending readmissions are dropped.
+func NewOutlierDetector(ctx context.Context, passiveHealthConfig *config.PassiveHealthConfig, serverPool interfaces.ServerPool) *OutlierDetector {
+	if passiveHealthConfig == nil {
+		return nil
+	}
+
+	od := &OutlierDetector{
+		consecutive5xx:      utils.OrDefault(passiveHealthConfig.Consecutive5xx, 5),
+		consecutiveFailures: utils.OrDefault(passiveHealthConfig.ConsecutiveFailures, 3),
+		latencyFactor:       passiveHealthConfig.LatencyFactor,
+		minRequests:         utils.OrDefault(passiveHealthConfig.MinRequests, 20),
+		baseEjectionTime:    time.Duration(utils.OrDefault(passiveHealthConfig.BaseEjectionTime, 30)) * time.Second,
+		maxEjectionTime:     time.Duration(utils.OrDefault(passiveHealthConfig.MaxEjectionTime, 300)) * time.Second,
+		maxEjectionPercent:  utils.OrDefault(passiveHealthConfig.MaxEjectionPercent, 50),
+		ctx:                 ctx,
+		serverPool:          serverPool,
+		stats:               map[interfaces.Backend]*outlierStats{},
+	}
+	context.AfterFunc(ctx, od.stop)
+
+	return od
+}
+
+// stop cancels the pending readmissions.
+func (od *OutlierDetector) stop() {
+	od.mux.Lock()
+	defer od.mux.Unlock()
+
+	for _, stats := range od.stats {
+		if stats.readmission != nil {
+			stats.readmission.Stop()
+			stats.readmission = nil
+		}
+	}
+}
+
+// ObserveResponse records a response received from a backend and its time to headers.
+func (od *OutlierDetector) ObserveResponse(b interfaces.Backend, status int, latency time.Duration) {
+	if od == nil {
+		return
+	}
+
+	od.mux.Lock()
+	defer od.mux.Unlock()
+
+	stats := od.getStats(b)
+	stats.consecutiveFailures = 0
+	if status >= 500 {
+		stats.consecutive5xx++
+	} else {
+		stats.consecutive5xx = 0
+	}
+
+	ms := float64(latency.Microseconds()) / 1000
+	if stats.samples == 0 {
+		stats.latency = ms
+	} else {
+		stats.latency = latencyWeight*ms + (1-latencyWeight)*stats.latency
+	}
+	stats.samples++
+
+	switch {
+	case stats.consecutive5xx >= od.consecutive5xx:
+		od.eject(b, stats, "consecutive 5xx responses")
+	case od.isLatencyOutlier(b, stats):
+		od.eject(b, stats, "latency outlier")
+	}
+}
+
+// ObserveFailure records a request that could not reach the backend.
+func (od *OutlierDetector) ObserveFailure(b interfaces.Backend) {
+	if od == nil {
+		return
+	}
+
+	od.mux.Lock()
+	defer od.mux.Unlock()
+
+	stats := od.getStats(b)
+	stats.consecutiveFailures++
+	if stats.consecutiveFailures >= od.consecutiveFailures {
+		od.eject(b, stats, "consecutive connection failures")
+	}
+}
+
+func (od *OutlierDetector) getStats(b interfaces.Backend) *outlierStats {
+	stats, ok := od.stats[b]
+	if !ok {
+		stats = &outlierStats{}
+		od.stats[b] = stats
+	}
+	return stats
+}
+
+// isLatencyOutlier compares the latency of a backend with the median of the other
+// backends that served at least min_requests requests.
+func (od *OutlierDetector) isLatencyOutlier(b interfaces.Backend, stats *outlierStats) bool {
+	if od.latencyFactor == 0 || stats.samples < od.minRequests {
+		return false
+	}
+
+	latencies := []float64{}
+	for other, otherStats := range od.stats {
+		if other != b && otherStats.samples >= od.minRequests && !other.IsEjected() {
+			latencies = append(latencies, otherStats.latency)
+		}
+	}
+	if len(latencies) == 0 {
+		return false
+	}
+
+	slices.Sort(latencies)
+	median := latencies[len(latencies)/2]
+	return stats.latency > od.latencyFactor*median
+}
+
+// eject must be called with the lock held.
+func (od *OutlierDetector) eject(b interfaces.Backend, stats *outlierStats, reason string) {
+	if b.IsEjected() || od.ctx.Err() != nil {
+		return
+	}
+
+	backends := od.serverPool.GetBackends()
+	ejected := 0
+	for _, other := range backends {
+		if other.IsEjected() {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > od.maxEjectionPercent*len(backends) {
+		utils.Logger.Warn("Backend not ejected, max_ejection_percent reached",
+			"URL", b.GetURL().String(), "reason", reason, "ejected", ejected, "backends", len(backends))
+		return
+	}
+
+	// A backend that behaved for max_ejection_time since its last readmission starts
+	// over from base_ejection_time.
+	if !stats.readmittedAt.IsZero() && time.Since(stats.readmittedAt) > od.maxEjectionTime {
+		stats.ejections = 0
+	}
+
+	duration := od.baseEjectionTime
+	for i := 0; i < stats.ejections && duration < od.maxEjectionTime; i++ {
+		duration *= 2
+	}
+	duration = min(duration, od.maxEjectionTime)
+	stats.ejections++
+
+	b.SetEjected(true)
+	utils.Logger.Warn("Backend ejected",
+		"URL", b.GetURL().String(), "reason", reason, "duration", duration.String(), "ejections", stats.ejections)
+
+	stats.readmission = time.AfterFunc(duration, func() {
+		od.readmit(b)
+	})
+}
+
+func (od *OutlierDetector) readmit(b interfaces.Backend) {
+	od.mux.Lock()
+	defer od.mux.Unlock()
+
+	stats := od.getStats(b)
+	stats.consecutive5xx = 0
+	stats.consecutiveFailures = 0
+	stats.samples = 0
+	stats.readmittedAt = time.Now()
+	stats.readmission = nil
+
+	b.SetEjected(false)
+	utils.Logger.Info("Backend readmitted", "URL", b.GetURL().String())
+}
@@ -0,0 +1,158 @@
+package serverpool
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/interfaces"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+)
+
+func newTestDetector(t *testing.T, ctx context.Context, passiveHealthConfig config.PassiveHealthConfig, backends ...interfaces.Backend) *OutlierDetector {
+	t.Helper()
+
+	utils.GetLogger()
+	return NewOutlierDetector(ctx, &passiveHealthConfig, newTestPool(t, RoundRobin, "", backends...))
+}
+
+func TestOutlierDetectorEjects(t *testing.T) {
+	tests := []struct {
+		name    string
+		observe func(od *OutlierDetector, b interfaces.Backend)
+		want    bool
+	}{
+		{
+			name: "consecutive 5xx responses",
+			observe: func(od *OutlierDetector, b interfaces.Backend) {
+				for i := 0; i < 3; i++ {
+					od.ObserveResponse(b, http.StatusBadGateway, time.Millisecond)
+				}
+			},
+			want: true,
+		},
+		{
+			name: "a success resets consecutive 5xx responses",
+			observe: func(od *OutlierDetector, b interfaces.Backend) {
+				od.ObserveResponse(b, http.StatusBadGateway, time.Millisecond)
+				od.ObserveResponse(b, http.StatusBadGateway, time.Millisecond)
+				od.ObserveResponse(b, http.StatusOK, time.Millisecond)
+				od.ObserveResponse(b, http.StatusBadGateway, time.Millisecond)
+			},
+			want: false,
+		},
+		{
+			name: "consecutive connection failures",
+			observe: func(od *OutlierDetector, b interfaces.Backend) {
+				od.ObserveFailure(b)
+				od.ObserveFailure(b)
+			},
+			want: true,
+		},
+		{
+			name: "a response resets consecutive connection failures",
+			observe: func(od *OutlierDetector, b interfaces.Backend) {
+				od.ObserveFailure(b)
+				od.ObserveResponse(b, http.StatusOK, time.Millisecond)
+				od.ObserveFailure(b)
+			},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := newTestBackend("http://a", 1), newTestBackend("http://b", 1)
+			od := newTestDetector(t, t.Context(), config.PassiveHealthConfig{Consecutive5xx: 3, ConsecutiveFailures: 2}, a, b)
+
+			tt.observe(od, a)
+			if a.IsEjected() != tt.want {
+				t.Errorf("ejected = %v, want %v", a.IsEjected(), tt.want)
+			}
+		})
+	}
+}
+
+func TestOutlierDetectorLatencyOutlier(t *testing.T) {
+	a, b, c := newTestBackend("http://a", 1), newTestBackend("http://b", 1), newTestBackend("http://c", 1)
+	od := newTestDetector(t, t.Context(), config.PassiveHealthConfig{LatencyFactor: 3, MinRequests: 5}, a, b, c)
+
+	for i := 0; i < 5; i++ {
+		od.ObserveResponse(b, http.StatusOK, 10*time.Millisecond)
+		od.ObserveResponse(c, http.StatusOK, 12*time.Millisecond)
+		od.ObserveResponse(a, http.StatusOK, 20*time.Millisecond)
+	}
+	if a.IsEjected() {
+		t.Fatal("a backend within latency_factor of the median must not be ejected")
+	}
+
+	for i := 0; i < 20; i++ {
+		od.ObserveResponse(a, http.StatusOK, 200*time.Millisecond)
+	}
+	if !a.IsEjected() {
+		t.Error("expected the slow backend to be ejected")
+	}
+}
+
+func TestOutlierDetectorMaxEjectionPercent(t *testing.T) {
+	a, b := newTestBackend("http://a", 1), newTestBackend("http://b", 1)
+	od := newTestDetector(t, t.Context(), config.PassiveHealthConfig{ConsecutiveFailures: 1}, a, b)
+
+	od.ObserveFailure(a)
+	od.ObserveFailure(b)
+	if !a.IsEjected() || b.IsEjected() {
+		t.Errorf("ejected a = %v, b = %v, want only a with max_ejection_percent 50", a.IsEjected(), b.IsEjected())
+	}
+}
+
+func TestOutlierDetectorReadmits(t *testing.T) {
+	a, b := newTestBackend("http://a", 1), newTestBackend("http://b", 1)
+	od := newTestDetector(t, t.Context(), config.PassiveHealthConfig{ConsecutiveFailures: 1}, a, b)
+
+	od.ObserveFailure(a)
+	if !a.IsEjected() {
+		t.Fatal("expected the backend to be ejected")
+	}
+
+	od.readmit(a)
+	if a.IsEjected() {
+		t.Error("expected the backend to be readmitted")
+	}
+	if stats := od.stats[a]; stats.ejections != 1 || stats.consecutiveFailures != 0 || stats.readmittedAt.IsZero() {
+		t.Errorf("unexpected stats after readmission: %+v", stats)
+	}
+}
+
+func TestOutlierDetectorStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	a, b, c := newTestBackend("http://a", 1), newTestBackend("http://b", 1), newTestBackend("http://c", 1)
+	od := newTestDetector(t, ctx, config.PassiveHealthConfig{ConsecutiveFailures: 1, BaseEjectionTime: 1, MaxEjectionPercent: 100}, a, b, c)
+
+	od.ObserveFailure(a)
+	if !a.IsEjected() {
+		t.Fatal("expected the backend to be ejected")
+	}
+
+	cancel()
+	time.Sleep(1500 * time.Millisecond)
+	if !a.IsEjected() {
+		t.Error("a pending readmission must be dropped once the context is cancelled")
+	}
+
+	od.ObserveFailure(b)
+	if b.IsEjected() {
+		t.Error("no backend must be ejected once the context is cancelled")
+	}
+}
+
+func TestNilOutlierDetector(t *testing.T) {
+	if od := NewOutlierDetector(t.Context(), nil, nil); od != nil {
+		t.Fatal("expected no detector without configuration")
+	}
+
+	var od *OutlierDetector
+	od.ObserveResponse(newTestBackend("http://a", 1), http.StatusBadGateway, time.Millisecond)
+	od.ObserveFailure(newTestBackend("http://a", 1))
+}
@@ -17,22 +17,41 @@ func (s *randomServerPool) GetNextValidPeer(r *http.Request) interfaces.Backend
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
-	if len(s.backends) == 0 {
+	available := make([]interfaces.Backend, 0, len(s.backends))
+	for _, b := range s.backends {
+		if isCandidate(r, b) {
+			available = append(available, b)
+		}
+	}
+
+	if len(available) == 0 {
 		return nil
 	}
 
-	randomIndex := rand.Intn(len(s.backends))
-	return s.backends[randomIndex]
+	randomIndex := rand.Intn(len(available))
+	return available[randomIndex]
 }
 
 func (s *randomServerPool) AddBackend(b interfaces.Backend) {
+	s.mux.Lock()
 	s.backends = append(s.backends, b)
+	s.mux.Unlock()
+}
+
+func (s *randomServerPool) RemoveBackend(b interfaces.Backend) {
+	s.mux.Lock()
+	s.backends = removeBackend(s.backends, b)
+	s.mux.Unlock()
 }
 
 func (s *randomServerPool) GetServerPoolSize() int {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
 	return len(s.backends)
 }
 
 func (s *randomServerPool) GetBackends() []interfaces.Backend {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
 	return s.backends
 }
@@ -15,29 +15,45 @@ type roundRobinServerPool struct {
 
 func (s *roundRobinServerPool) Rotate() interfaces.Backend {
 	s.mux.Lock()
-	s.current = (s.current + 1) % s.GetServerPoolSize()
-	s.mux.Unlock()
+	defer s.mux.Unlock()
+
+	if len(s.backends) == 0 {
+		return nil
+	}
+	s.current = (s.current + 1) % len(s.backends)
 	return s.backends[s.current]
 }
 
 func (s *roundRobinServerPool) GetNextValidPeer(r *http.Request) interfaces.Backend {
 	for i := 0; i < s.GetServerPoolSize(); i++ {
 		nextPeer := s.Rotate()
-		if nextPeer.IsAlive() {
+		if nextPeer != nil && isCandidate(r, nextPeer) {
 			return nextPeer
 		}
 	}
 	return nil
 }
 
-func (s *roundRobinServerPool) GetBackends() []interfaces.Backend {
-	return s.backends
-}
-
 func (s *roundRobinServerPool) AddBackend(b interfaces.Backend) {
+	s.mux.Lock()
 	s.backends = append(s.backends, b)
+	s.mux.Unlock()
+}
+
+func (s *roundRobinServerPool) RemoveBackend(b interfaces.Backend) {
+	s.mux.Lock()
+	s.backends = removeBackend(s.backends, b)
+	s.mux.Unlock()
 }
 
 func (s *roundRobinServerPool) GetServerPoolSize() int {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
 	return len(s.backends)
 }
+
+func (s *roundRobinServerPool) GetBackends() []interfaces.Backend {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.backends
+}
@@ -4,41 +4,80 @@ package serverpool
 import (
 	"context"
 	"errors"
-	"time"
+	"net/http"
+	"slices"
+	"sync"
 
 	"github.com/letronghoangminh/reproxy/pkg/interfaces"
-	"github.com/letronghoangminh/reproxy/pkg/services/proxy/backend"
-	"github.com/letronghoangminh/reproxy/pkg/utils"
 )
 
-func HealthCheck(ctx context.Context, s interfaces.ServerPool) {
-	aliveChannel := make(chan bool, 1)
+// Attempted records the backends a request was already sent to, so that its retries
+// are sent to other backends.
+type Attempted struct {
+	mux      sync.Mutex
+	backends []interfaces.Backend
+}
+
+type attemptedKey struct{}
 
-	for _, b := range s.GetBackends() {
-		requestCtx, stop := context.WithTimeout(ctx, 10*time.Second)
-		defer stop()
-		status := "up"
-		go backend.IsBackendAlive(requestCtx, aliveChannel, b.GetURL())
+// WithAttempted returns a context carrying the attempted backends of a request.
+func WithAttempted(ctx context.Context, attempted *Attempted) context.Context {
+	return context.WithValue(ctx, attemptedKey{}, attempted)
+}
 
-		select {
-		case <-ctx.Done():
-			utils.Logger.Info("Gracefully shutting down health check")
-			return
-		case alive := <-aliveChannel:
-			b.SetAlive(alive)
-			if !alive {
-				status = "down"
-			}
+// WithoutAttempted returns a copy of r whose backends are all candidates again, for
+// when every available backend was already attempted.
+func WithoutAttempted(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), attemptedKey{}, (*Attempted)(nil)))
+}
+
+// HasAttempted reports whether r was already sent to a backend.
+func HasAttempted(r *http.Request) bool {
+	attempted, _ := r.Context().Value(attemptedKey{}).(*Attempted)
+	if attempted == nil {
+		return false
+	}
+	attempted.mux.Lock()
+	defer attempted.mux.Unlock()
+	return len(attempted.backends) > 0
+}
+
+func (a *Attempted) Add(b interfaces.Backend) {
+	a.mux.Lock()
+	a.backends = append(a.backends, b)
+	a.mux.Unlock()
+}
+
+func (a *Attempted) contains(b interfaces.Backend) bool {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return slices.Contains(a.backends, b)
+}
+
+// isCandidate reports whether b may serve r: it is available and r was not already
+// sent to it.
+func isCandidate(r *http.Request, b interfaces.Backend) bool {
+	if !b.IsAvailable() {
+		return false
+	}
+	attempted, _ := r.Context().Value(attemptedKey{}).(*Attempted)
+	return attempted == nil || !attempted.contains(b)
+}
+
+func removeBackend(backends []interfaces.Backend, target interfaces.Backend) []interfaces.Backend {
+	remaining := make([]interfaces.Backend, 0, len(backends))
+	for _, b := range backends {
+		if b != target {
+			remaining = append(remaining, b)
 		}
-		utils.Logger.Debug(
-			"URL Status",
-			"URL", b.GetURL().String(),
-			"status", status,
-		)
 	}
+	return remaining
 }
 
-func NewServerPool(strategy LBStrategy) (interfaces.ServerPool, error) {
+// NewServerPool creates the server pool of a strategy. hashKey selects the request
+// value hashed by the hash strategies and defaults to the client IP for ip_hash and
+// consistent_hash, and to the path for uri_hash.
+func NewServerPool(strategy LBStrategy, hashKey string) (interfaces.ServerPool, error) {
 	switch strategy {
 	case RoundRobin:
 		return &roundRobinServerPool{
@@ -53,19 +92,30 @@ func NewServerPool(strategy LBStrategy) (interfaces.ServerPool, error) {
 		return &randomServerPool{
 			backends: make([]interfaces.Backend, 0),
 		}, nil
-	case IPHash:
-		return &ipServerPool{
-			backends: make([]interfaces.Backend, 0),
-		}, nil
+	case IPHash, ConsistentHash:
+		if hashKey == "" {
+			hashKey = "remote_ip"
+		}
+		return newHashServerPool(hashKey)
 	case URIHash:
-		return &uriServerPool{
-			backends: make([]interfaces.Backend, 0),
-		}, nil
+		if hashKey == "" {
+			hashKey = "path"
+		}
+		return newHashServerPool(hashKey)
 	case Sticky:
 		return &stickyServerPool{
 			backends: make([]interfaces.Backend, 0),
 			current:  0,
 		}, nil
+	case WeightedRoundRobin:
+		return &wrrServerPool{
+			backends: make([]interfaces.Backend, 0),
+			current:  map[interfaces.Backend]int{},
+		}, nil
+	case WeightedLeastConnections:
+		return &wlcServerPool{
+			backends: make([]interfaces.Backend, 0),
+		}, nil
 	default:
 		return nil, errors.New("invalid strategy")
 	}
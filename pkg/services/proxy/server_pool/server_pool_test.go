@@ -0,0 +1,103 @@
+package serverpool
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/letronghoangminh/reproxy/pkg/interfaces"
+)
+
+// testBackend is a backend whose availability and connections are set by the tests.
+type testBackend struct {
+	url         *url.URL
+	weight      int
+	connections int
+	alive       bool
+	disabled    bool
+	draining    bool
+	ejected     bool
+	cookies     []*http.Cookie
+}
+
+func newTestBackend(rawURL string, weight int) *testBackend {
+	u, _ := url.Parse(rawURL)
+	return &testBackend{url: u, weight: weight, alive: true}
+}
+
+func (b *testBackend) SetAlive(alive bool)                { b.alive = alive }
+func (b *testBackend) IsAlive() bool                      { return b.alive }
+func (b *testBackend) SetDisabled(disabled bool)          { b.disabled = disabled }
+func (b *testBackend) IsDisabled() bool                   { return b.disabled }
+func (b *testBackend) SetDraining(draining bool)          { b.draining = draining }
+func (b *testBackend) IsDraining() bool                   { return b.draining }
+func (b *testBackend) SetEjected(ejected bool)            { b.ejected = ejected }
+func (b *testBackend) IsEjected() bool                    { return b.ejected }
+func (b *testBackend) RecordResult(context.Context, bool) {}
+func (b *testBackend) GetCircuitState() string            { return "" }
+func (b *testBackend) GetURL() *url.URL                   { return b.url }
+func (b *testBackend) GetWeight() int                     { return b.weight }
+func (b *testBackend) GetActiveConnections() int          { return b.connections }
+
+func (b *testBackend) Serve(http.ResponseWriter, *http.Request) {}
+
+func (b *testBackend) AddCookie(cookie *http.Cookie) {
+	b.cookies = append(b.cookies, cookie)
+}
+
+func (b *testBackend) IsAvailable() bool {
+	return b.alive && !b.disabled && !b.draining && !b.ejected
+}
+
+func newTestPool(t *testing.T, strategy LBStrategy, hashKey string, backends ...interfaces.Backend) interfaces.ServerPool {
+	t.Helper()
+
+	pool, err := NewServerPool(strategy, hashKey)
+	if err != nil {
+		t.Fatalf("NewServerPool: %v", err)
+	}
+	for _, b := range backends {
+		pool.AddBackend(b)
+	}
+	return pool
+}
+
+func backendHost(b interfaces.Backend) string {
+	if b == nil {
+		return ""
+	}
+	return b.GetURL().Host
+}
+
+func TestIsCandidate(t *testing.T) {
+	a := newTestBackend("http://a", 1)
+	b := newTestBackend("http://b", 1)
+
+	attempted := &Attempted{}
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(WithAttempted(context.Background(), attempted))
+
+	if HasAttempted(r) {
+		t.Error("expected no attempted backend yet")
+	}
+	attempted.Add(a)
+	if !HasAttempted(r) {
+		t.Error("expected an attempted backend")
+	}
+	if isCandidate(r, a) {
+		t.Error("an attempted backend must not be a candidate")
+	}
+	if !isCandidate(r, b) {
+		t.Error("a backend not yet attempted must be a candidate")
+	}
+	if !isCandidate(WithoutAttempted(r), a) {
+		t.Error("WithoutAttempted must make every available backend a candidate")
+	}
+
+	b.SetDraining(true)
+	if isCandidate(r, b) {
+		t.Error("an unavailable backend must not be a candidate")
+	}
+}
@@ -5,9 +5,16 @@ import (
 	"strconv"
 	"sync"
 
+	"github.com/cespare/xxhash/v2"
 	"github.com/letronghoangminh/reproxy/pkg/interfaces"
 )
 
+const stickyCookieName = "X-Sticky-Session-ID"
+
+// stickyServerPool pins clients to a backend with a cookie carrying the ID of the
+// backend, derived from its URL so that it does not change when other backends are
+// added or removed. New clients and clients of an unavailable backend are assigned one
+// in round robin.
 type stickyServerPool struct {
 	backends []interfaces.Backend
 	mux      sync.RWMutex
@@ -16,33 +23,31 @@ type stickyServerPool struct {
 
 func (s *stickyServerPool) Rotate() interfaces.Backend {
 	s.mux.Lock()
-	s.current = (s.current + 1) % s.GetServerPoolSize()
-	s.mux.Unlock()
+	defer s.mux.Unlock()
+
+	if len(s.backends) == 0 {
+		return nil
+	}
+	s.current = (s.current + 1) % len(s.backends)
 	return s.backends[s.current]
 }
 
 func (s *stickyServerPool) GetNextValidPeer(r *http.Request) interfaces.Backend {
-	stickyCookie, err := r.Cookie("X-Sticky-Session-ID")
-	if err == nil {
-		stickySessionID, err := strconv.Atoi(stickyCookie.Value)
-
-		if err == nil {
-			for idx, b := range s.backends {
-				if b.IsAlive() && stickySessionID == idx {
-					return b
-				}
+	if stickyCookie, err := r.Cookie(stickyCookieName); err == nil {
+		for _, b := range s.GetBackends() {
+			if backendID(b) == stickyCookie.Value && isCandidate(r, b) {
+				return b
 			}
 		}
 	}
 
 	for i := 0; i < s.GetServerPoolSize(); i++ {
 		nextPeer := s.Rotate()
-		if nextPeer.IsAlive() {
-			cookie := &http.Cookie{
-				Name:  "X-Sticky-Session-ID",
-				Value: strconv.Itoa(s.current),
-			}
-			nextPeer.AddCookie(cookie)
+		if nextPeer != nil && isCandidate(r, nextPeer) {
+			nextPeer.AddCookie(&http.Cookie{
+				Name:  stickyCookieName,
+				Value: backendID(nextPeer),
+			})
 			return nextPeer
 		}
 	}
@@ -50,14 +55,31 @@ func (s *stickyServerPool) GetNextValidPeer(r *http.Request) interfaces.Backend
 	return nil
 }
 
-func (s *stickyServerPool) GetBackends() []interfaces.Backend {
-	return s.backends
+// backendID identifies a backend by a hash of its URL.
+func backendID(b interfaces.Backend) string {
+	return strconv.FormatUint(xxhash.Sum64String(b.GetURL().String()), 16)
 }
 
 func (s *stickyServerPool) AddBackend(b interfaces.Backend) {
+	s.mux.Lock()
 	s.backends = append(s.backends, b)
+	s.mux.Unlock()
+}
+
+func (s *stickyServerPool) RemoveBackend(b interfaces.Backend) {
+	s.mux.Lock()
+	s.backends = removeBackend(s.backends, b)
+	s.mux.Unlock()
 }
 
 func (s *stickyServerPool) GetServerPoolSize() int {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
 	return len(s.backends)
 }
+
+func (s *stickyServerPool) GetBackends() []interfaces.Backend {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.backends
+}
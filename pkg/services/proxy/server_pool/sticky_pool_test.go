@@ -0,0 +1,68 @@
+package serverpool
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func stickyRequest(cookie string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	if cookie != "" {
+		r.AddCookie(&http.Cookie{Name: stickyCookieName, Value: cookie})
+	}
+	return r
+}
+
+func TestStickyPool(t *testing.T) {
+	a, b, c := newTestBackend("http://a", 1), newTestBackend("http://b", 1), newTestBackend("http://c", 1)
+	pool := newTestPool(t, Sticky, "", a, b, c)
+
+	first := pool.GetNextValidPeer(stickyRequest("")).(*testBackend)
+	if len(first.cookies) != 1 || first.cookies[0].Value != backendID(first) {
+		t.Fatalf("new client was given cookies %v, want the ID of %s", first.cookies, backendHost(first))
+	}
+	cookie := first.cookies[0].Value
+
+	for i := 0; i < 5; i++ {
+		if peer := pool.GetNextValidPeer(stickyRequest(cookie)); peer != first {
+			t.Fatalf("pinned client went to %s, want %s", backendHost(peer), backendHost(first))
+		}
+	}
+
+	// Removing another backend must not move the client.
+	for _, other := range []*testBackend{a, b, c} {
+		if other != first {
+			pool.RemoveBackend(other)
+			break
+		}
+	}
+	if peer := pool.GetNextValidPeer(stickyRequest(cookie)); peer != first {
+		t.Fatalf("pinned client went to %s after a removal, want %s", backendHost(peer), backendHost(first))
+	}
+
+	first.SetDraining(true)
+	if peer := pool.GetNextValidPeer(stickyRequest(cookie)); peer == nil || peer == first {
+		t.Fatalf("client of a draining backend went to %s, want another backend", backendHost(peer))
+	}
+}
+
+func TestStickyPoolUnknownCookie(t *testing.T) {
+	a := newTestBackend("http://a", 1)
+	pool := newTestPool(t, Sticky, "", a)
+
+	for _, cookie := range []string{"0", "garbage"} {
+		if peer := pool.GetNextValidPeer(stickyRequest(cookie)); peer != a {
+			t.Errorf("client with cookie %q went to %s, want a", cookie, backendHost(peer))
+		}
+	}
+}
+
+func TestBackendIDIsStable(t *testing.T) {
+	if backendID(newTestBackend("http://a:8080", 1)) != backendID(newTestBackend("http://a:8080", 2)) {
+		t.Error("the ID must only depend on the backend URL")
+	}
+	if backendID(newTestBackend("http://a:8080", 1)) == backendID(newTestBackend("http://a:8081", 1)) {
+		t.Error("backends with different URLs must have different IDs")
+	}
+}
@@ -0,0 +1,61 @@
+package serverpool
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/letronghoangminh/reproxy/pkg/interfaces"
+)
+
+// wlcServerPool picks the backend with the fewest active connections per unit of
+// weight, so a backend of weight 2 is given twice as many connections.
+type wlcServerPool struct {
+	backends []interfaces.Backend
+	mux      sync.RWMutex
+}
+
+func (s *wlcServerPool) GetNextValidPeer(r *http.Request) interfaces.Backend {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var selected interfaces.Backend
+	for _, b := range s.backends {
+		if !isCandidate(r, b) {
+			continue
+		}
+		if selected == nil {
+			selected = b
+			continue
+		}
+
+		// connections/weight compared without division: a/wa < b/wb <=> a*wb < b*wa
+		if b.GetActiveConnections()*selected.GetWeight() < selected.GetActiveConnections()*b.GetWeight() {
+			selected = b
+		}
+	}
+	return selected
+}
+
+func (s *wlcServerPool) AddBackend(b interfaces.Backend) {
+	s.mux.Lock()
+	s.backends = append(s.backends, b)
+	s.mux.Unlock()
+}
+
+func (s *wlcServerPool) RemoveBackend(b interfaces.Backend) {
+	s.mux.Lock()
+	s.backends = removeBackend(s.backends, b)
+	s.mux.Unlock()
+}
+
+func (s *wlcServerPool) GetServerPoolSize() int {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return len(s.backends)
+}
+
+func (s *wlcServerPool) GetBackends() []interfaces.Backend {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.backends
+}
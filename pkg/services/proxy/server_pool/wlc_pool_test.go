@@ -0,0 +1,69 @@
+package serverpool
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWLCPool(t *testing.T) {
+	tests := []struct {
+		name     string
+		backends []*testBackend
+		want     string
+	}{
+		{
+			name:     "fewest connections per weight",
+			backends: []*testBackend{{weight: 1, connections: 1}, {weight: 2, connections: 1}},
+			want:     "b",
+		},
+		{
+			name:     "weight does not outrank an idle backend",
+			backends: []*testBackend{{weight: 1, connections: 0}, {weight: 5, connections: 1}},
+			want:     "a",
+		},
+		{
+			name:     "ties go to the first backend",
+			backends: []*testBackend{{weight: 1, connections: 2}, {weight: 2, connections: 4}},
+			want:     "a",
+		},
+		{
+			name:     "unavailable backends are skipped",
+			backends: []*testBackend{{weight: 1, connections: 9}, {weight: 1, ejected: true}, {weight: 1, draining: true}},
+			want:     "a",
+		},
+		{
+			name:     "no available backend",
+			backends: []*testBackend{{weight: 1, ejected: true}},
+			want:     "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newTestPool(t, WeightedLeastConnections, "")
+			for i, b := range tt.backends {
+				b.url = newTestBackend("http://"+string(rune('a'+i)), b.weight).url
+				b.alive = true
+				pool.AddBackend(b)
+			}
+
+			if got := backendHost(pool.GetNextValidPeer(httptest.NewRequest("GET", "/", nil))); got != tt.want {
+				t.Errorf("picked %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWLCPoolSkipsAttemptedBackends(t *testing.T) {
+	a, b := newTestBackend("http://a", 1), newTestBackend("http://b", 1)
+	b.connections = 10
+	pool := newTestPool(t, WeightedLeastConnections, "", a, b)
+
+	attempted := &Attempted{}
+	attempted.Add(a)
+	r := httptest.NewRequest("GET", "/", nil).WithContext(WithAttempted(context.Background(), attempted))
+	if peer := pool.GetNextValidPeer(r); peer != b {
+		t.Errorf("retry went to %s, want b", backendHost(peer))
+	}
+}
@@ -0,0 +1,66 @@
+package serverpool
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/letronghoangminh/reproxy/pkg/interfaces"
+)
+
+// wrrServerPool implements smooth weighted round robin: every pick raises the current
+// weight of each backend by its weight and selects the highest one, which then gives
+// back the total. Backends are interleaved instead of being picked in bursts.
+type wrrServerPool struct {
+	backends []interfaces.Backend
+	current  map[interfaces.Backend]int
+	mux      sync.RWMutex
+}
+
+func (s *wrrServerPool) GetNextValidPeer(r *http.Request) interfaces.Backend {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var selected interfaces.Backend
+	total := 0
+	for _, b := range s.backends {
+		if !isCandidate(r, b) {
+			continue
+		}
+
+		s.current[b] += b.GetWeight()
+		total += b.GetWeight()
+		if selected == nil || s.current[b] > s.current[selected] {
+			selected = b
+		}
+	}
+
+	if selected != nil {
+		s.current[selected] -= total
+	}
+	return selected
+}
+
+func (s *wrrServerPool) AddBackend(b interfaces.Backend) {
+	s.mux.Lock()
+	s.backends = append(s.backends, b)
+	s.mux.Unlock()
+}
+
+func (s *wrrServerPool) RemoveBackend(b interfaces.Backend) {
+	s.mux.Lock()
+	s.backends = removeBackend(s.backends, b)
+	delete(s.current, b)
+	s.mux.Unlock()
+}
+
+func (s *wrrServerPool) GetServerPoolSize() int {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return len(s.backends)
+}
+
+func (s *wrrServerPool) GetBackends() []interfaces.Backend {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.backends
+}
@@ -0,0 +1,76 @@
+package serverpool
+
+import (
+	"context"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func pickHosts(picks int, pick func() string) []string {
+	hosts := make([]string, picks)
+	for i := range hosts {
+		hosts[i] = pick()
+	}
+	return hosts
+}
+
+func TestWRRPoolSmoothSequence(t *testing.T) {
+	pool := newTestPool(t, WeightedRoundRobin, "",
+		newTestBackend("http://a", 5),
+		newTestBackend("http://b", 1),
+		newTestBackend("http://c", 1),
+	)
+	r := httptest.NewRequest("GET", "/", nil)
+
+	got := pickHosts(14, func() string { return backendHost(pool.GetNextValidPeer(r)) })
+	want := []string{"a", "a", "b", "a", "c", "a", "a", "a", "a", "b", "a", "c", "a", "a"}
+	if !slices.Equal(got, want) {
+		t.Errorf("picks = %v, want %v", got, want)
+	}
+}
+
+func TestWRRPoolSkipsUnavailableBackends(t *testing.T) {
+	a, b, c := newTestBackend("http://a", 3), newTestBackend("http://b", 2), newTestBackend("http://c", 1)
+	pool := newTestPool(t, WeightedRoundRobin, "", a, b, c)
+	r := httptest.NewRequest("GET", "/", nil)
+
+	a.SetEjected(true)
+	counts := countHosts(pickHosts(30, func() string { return backendHost(pool.GetNextValidPeer(r)) }))
+	if counts["a"] != 0 || counts["b"] != 20 || counts["c"] != 10 {
+		t.Errorf("picks = %v, want b and c in a 2:1 ratio", counts)
+	}
+
+	b.SetDisabled(true)
+	c.SetAlive(false)
+	if peer := pool.GetNextValidPeer(r); peer != nil {
+		t.Errorf("expected no peer when every backend is unavailable, got %s", backendHost(peer))
+	}
+}
+
+func TestWRRPoolSkipsAttemptedBackends(t *testing.T) {
+	a, b := newTestBackend("http://a", 10), newTestBackend("http://b", 1)
+	pool := newTestPool(t, WeightedRoundRobin, "", a, b)
+
+	attempted := &Attempted{}
+	attempted.Add(a)
+	r := httptest.NewRequest("GET", "/", nil).WithContext(WithAttempted(context.Background(), attempted))
+	if peer := pool.GetNextValidPeer(r); peer != b {
+		t.Errorf("retry went to %s, want b", backendHost(peer))
+	}
+}
+
+func TestWRRPoolRemoveBackend(t *testing.T) {
+	a, b := newTestBackend("http://a", 1), newTestBackend("http://b", 1)
+	pool := newTestPool(t, WeightedRoundRobin, "", a, b)
+	r := httptest.NewRequest("GET", "/", nil)
+	pool.GetNextValidPeer(r)
+
+	pool.RemoveBackend(a)
+	if _, ok := pool.(*wrrServerPool).current[a]; ok {
+		t.Error("the current weight of a removed backend must be forgotten")
+	}
+	if peer := pool.GetNextValidPeer(r); peer != b {
+		t.Errorf("picked %s, want b", backendHost(peer))
+	}
+}
@@ -0,0 +1,259 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+)
+
+// TransportStats are the connection counters of the transports of a handler.
+type TransportStats struct {
+	OpenConnections   int64
+	Dials             int64
+	DialErrors        int64
+	Requests          int64
+	ReusedConnections int64
+}
+
+type transportStats struct {
+	openConnections   atomic.Int64
+	dials             atomic.Int64
+	dialErrors        atomic.Int64
+	requests          atomic.Int64
+	reusedConnections atomic.Int64
+}
+
+func (s *transportStats) snapshot() TransportStats {
+	return TransportStats{
+		OpenConnections:   s.openConnections.Load(),
+		Dials:             s.dials.Load(),
+		DialErrors:        s.dialErrors.Load(),
+		Requests:          s.requests.Load(),
+		ReusedConnections: s.reusedConnections.Load(),
+	}
+}
+
+// countedConn keeps the number of open upstream connections up to date.
+type countedConn struct {
+	net.Conn
+	stats *transportStats
+	once  sync.Once
+}
+
+func (c *countedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.stats.openConnections.Add(-1)
+	})
+	return err
+}
+
+// instrumentedTransport counts requests and how many of them reused a connection.
+// upgrade is the HTTP/1 transport of the upgrade requests when the transport speaks
+// h2c, since HTTP/2 has no Upgrade header.
+type instrumentedTransport struct {
+	*http.Transport
+	h2c   *http.Transport
+	stats *transportStats
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if t.h2c != nil && req.URL.Scheme == "http" && !utils.IsUpgradeRequest(req) {
+		transport = t.h2c
+	}
+
+	t.stats.requests.Add(1)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				t.stats.reusedConnections.Add(1)
+			}
+		},
+	}
+	return transport.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+func (t *instrumentedTransport) CloseIdleConnections() {
+	t.Transport.CloseIdleConnections()
+	if t.h2c != nil {
+		t.h2c.CloseIdleConnections()
+	}
+}
+
+// newUpstreamTLSConfig loads the tls section of a reverse proxy handler. It returns nil
+// when the section is missing, in which case upstreams are verified against the system
+// roots.
+func newUpstreamTLSConfig(upstreamTLSConfig *config.UpstreamTLSConfig) (*tls.Config, error) {
+	if upstreamTLSConfig == nil {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         upstreamTLSConfig.ServerName,
+		InsecureSkipVerify: upstreamTLSConfig.InsecureSkipVerify,
+	}
+
+	if upstreamTLSConfig.CA != "" {
+		pemData, err := os.ReadFile(upstreamTLSConfig.CA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read upstream CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("no certificates found in upstream CA %q", upstreamTLSConfig.CA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if upstreamTLSConfig.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(upstreamTLSConfig.CertFile, upstreamTLSConfig.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load upstream client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if upstreamTLSConfig.InsecureSkipVerify {
+		utils.Logger.Warn("TLS verification of upstream certificates is disabled")
+	}
+
+	return tlsConfig, nil
+}
+
+// newTransport builds an upstream transport from the timeouts and transport sections of
+// a handler, so that connections are reused and a hung backend cannot hold a request
+// forever.
+func newTransport(handler *config.HandlerConfig, stats *transportStats, tlsConfig *tls.Config) *instrumentedTransport {
+	dial := 10 * time.Second
+	tlsHandshake := 10 * time.Second
+	var responseHeader time.Duration
+	idleConn := 90 * time.Second
+
+	if timeoutsConfig := handler.ReverseProxy.Timeouts; timeoutsConfig != nil {
+		if timeoutsConfig.Dial > 0 {
+			dial = time.Duration(timeoutsConfig.Dial) * time.Second
+		}
+		if timeoutsConfig.TLSHandshake > 0 {
+			tlsHandshake = time.Duration(timeoutsConfig.TLSHandshake) * time.Second
+		}
+		if timeoutsConfig.ResponseHeader > 0 {
+			responseHeader = time.Duration(timeoutsConfig.ResponseHeader) * time.Second
+		}
+		if timeoutsConfig.IdleConn > 0 {
+			idleConn = time.Duration(timeoutsConfig.IdleConn) * time.Second
+		}
+	}
+
+	maxIdleConns := 100
+	maxIdleConnsPerHost := 32
+	maxConnsPerHost := 0
+	keepAlive := 30 * time.Second
+	protocols := &http.Protocols{}
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	h2c := false
+
+	if transportConfig := handler.ReverseProxy.Transport; transportConfig != nil {
+		if transportConfig.MaxIdleConns > 0 {
+			maxIdleConns = transportConfig.MaxIdleConns
+		}
+		if transportConfig.MaxIdleConnsPerHost > 0 {
+			maxIdleConnsPerHost = transportConfig.MaxIdleConnsPerHost
+		}
+		maxConnsPerHost = transportConfig.MaxConnsPerHost
+		if transportConfig.KeepAlive > 0 {
+			keepAlive = time.Duration(transportConfig.KeepAlive) * time.Second
+		}
+
+		switch transportConfig.HTTP2 {
+		case "off":
+			protocols.SetHTTP2(false)
+		case "h2c":
+			h2c = true
+		}
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   dial,
+		KeepAlive: keepAlive,
+	}
+
+	transport := &instrumentedTransport{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				stats.dials.Add(1)
+				conn, err := dialer.DialContext(ctx, network, addr)
+				if err != nil {
+					stats.dialErrors.Add(1)
+					return nil, err
+				}
+				stats.openConnections.Add(1)
+				return &countedConn{Conn: conn, stats: stats}, nil
+			},
+			Protocols:             protocols,
+			TLSClientConfig:       tlsConfig.Clone(),
+			MaxIdleConns:          maxIdleConns,
+			MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+			MaxConnsPerHost:       maxConnsPerHost,
+			TLSHandshakeTimeout:   tlsHandshake,
+			ResponseHeaderTimeout: responseHeader,
+			IdleConnTimeout:       idleConn,
+			ExpectContinueTimeout: time.Second,
+		},
+		stats: stats,
+	}
+
+	// Plain HTTP upstreams are spoken to in HTTP/2 directly by a separate transport,
+	// TLS upstreams still negotiate their protocol and upgrade requests stay on
+	// HTTP/1.1.
+	if h2c {
+		transport.h2c = transport.Transport.Clone()
+		transport.h2c.Protocols = &http.Protocols{}
+		transport.h2c.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	return transport
+}
+
+// flushInterval returns the flush interval of the responses of a handler, 0 to
+// buffer them and -1 to flush every write.
+func flushInterval(transportConfig *config.TransportConfig) time.Duration {
+	if transportConfig == nil {
+		return 0
+	}
+	return time.Duration(transportConfig.FlushInterval) * time.Millisecond
+}
+
+// requestTimeout returns the deadline of a whole request, 0 when it is disabled.
+func requestTimeout(timeoutsConfig *config.UpstreamTimeoutsConfig) time.Duration {
+	if timeoutsConfig == nil {
+		return 0
+	}
+	return time.Duration(timeoutsConfig.Request) * time.Second
+}
+
+// isTimeout reports whether a request failed because the upstream or the request
+// deadline timed out, which is answered with 504 Gateway Timeout.
+func isTimeout(ctx context.Context, err error) bool {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
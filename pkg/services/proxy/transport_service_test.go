@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+)
+
+func TestH2CTransportSendsUpgradesOverHTTP1(t *testing.T) {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+	}))
+	upstream.Config.Protocols = &http.Protocols{}
+	upstream.Config.Protocols.SetHTTP1(true)
+	upstream.Config.Protocols.SetUnencryptedHTTP2(true)
+	upstream.Start()
+	defer upstream.Close()
+
+	handler := &config.HandlerConfig{
+		ReverseProxy: config.ReverseProxyConfig{Transport: &config.TransportConfig{HTTP2: "h2c"}},
+	}
+	transport := newTransport(handler, &transportStats{}, nil)
+	defer transport.CloseIdleConnections()
+
+	tests := []struct {
+		name    string
+		upgrade bool
+		want    string
+	}{
+		{name: "plain request", want: "HTTP/2.0"},
+		{name: "upgrade request", upgrade: true, want: "HTTP/1.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", upstream.URL, nil)
+			if tt.upgrade {
+				req.Header.Set("Connection", "Upgrade")
+				req.Header.Set("Upgrade", "websocket")
+			}
+
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+
+			if got := resp.Header.Get("X-Proto"); got != tt.want {
+				t.Errorf("upstream protocol = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestH2CTransportNegotiatesWithTLSUpstreams(t *testing.T) {
+	tests := []struct {
+		name  string
+		http2 bool
+		want  string
+	}{
+		{name: "HTTP/1.1 only upstream", want: "HTTP/1.1"},
+		{name: "HTTP/2 upstream", http2: true, want: "HTTP/2.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Proto", r.Proto)
+			}))
+			upstream.EnableHTTP2 = tt.http2
+			upstream.StartTLS()
+			defer upstream.Close()
+
+			roots := x509.NewCertPool()
+			roots.AddCert(upstream.Certificate())
+			handler := &config.HandlerConfig{
+				ReverseProxy: config.ReverseProxyConfig{Transport: &config.TransportConfig{HTTP2: "h2c"}},
+			}
+			transport := newTransport(handler, &transportStats{}, &tls.Config{RootCAs: roots})
+			defer transport.CloseIdleConnections()
+
+			req, _ := http.NewRequest("GET", upstream.URL, nil)
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+
+			if got := resp.Header.Get("X-Proto"); got != tt.want {
+				t.Errorf("upstream protocol = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+)
+
+const (
+	defaultTunnelIdleTimeout = 300 * time.Second
+	tunnelCloseTimeout       = 5 * time.Second
+)
+
+// tunnelConn is the client side of an upgraded connection. Both directions of the
+// tunnel go through it, so every read or write pushes the idle deadline back. An
+// idleTimeout of 0 keeps idle tunnels open.
+type tunnelConn struct {
+	net.Conn
+	idleTimeout time.Duration
+	port        int
+	once        sync.Once
+	done        chan struct{}
+}
+
+var (
+	tunnels      = map[*tunnelConn]struct{}{}
+	tunnelsMutex sync.Mutex
+)
+
+func (c *tunnelConn) Read(b []byte) (int, error) {
+	if c.idleTimeout > 0 {
+		_ = c.Conn.SetDeadline(time.Now().Add(c.idleTimeout))
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *tunnelConn) Write(b []byte) (int, error) {
+	if c.idleTimeout > 0 {
+		_ = c.Conn.SetDeadline(time.Now().Add(c.idleTimeout))
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *tunnelConn) Close() error {
+	c.once.Do(func() {
+		tunnelsMutex.Lock()
+		delete(tunnels, c)
+		tunnelsMutex.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// upgradeResponseWriter hands the reverse proxy a tracked connection with an idle
+// timeout when it hijacks the client connection after a 101 Switching Protocols.
+type upgradeResponseWriter struct {
+	http.ResponseWriter
+	idleTimeout time.Duration
+	tunnel      *tunnelConn
+}
+
+func (w *upgradeResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tunnel := &tunnelConn{
+		Conn:        conn,
+		idleTimeout: w.idleTimeout,
+		done:        make(chan struct{}),
+	}
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		tunnel.port = addr.Port
+	}
+
+	tunnelsMutex.Lock()
+	tunnels[tunnel] = struct{}{}
+	tunnelsMutex.Unlock()
+
+	w.tunnel = tunnel
+	return tunnel, brw, nil
+}
+
+// finish signals that the proxy stopped copying the tunnel, once the handler returns.
+func (w *upgradeResponseWriter) finish() {
+	if w.tunnel != nil {
+		close(w.tunnel.done)
+	}
+}
+
+func (w *upgradeResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// CloseTunnels closes the upgraded connections accepted on a port and waits for their
+// handlers to return. http.Server.Shutdown does not track hijacked connections, so
+// servers call it once shut down.
+func CloseTunnels(port int) {
+	tunnelsMutex.Lock()
+	closing := make([]*tunnelConn, 0, len(tunnels))
+	for tunnel := range tunnels {
+		if tunnel.port == port {
+			closing = append(closing, tunnel)
+		}
+	}
+	tunnelsMutex.Unlock()
+
+	if len(closing) > 0 {
+		utils.Logger.Info("Closing upgraded connections", "port", port, "count", len(closing))
+	}
+
+	for _, tunnel := range closing {
+		_ = tunnel.Close()
+	}
+
+	timeout := time.After(tunnelCloseTimeout)
+	for _, tunnel := range closing {
+		select {
+		case <-tunnel.done:
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// tunnelIdleTimeout returns the idle timeout of upgraded connections, 0 when it is
+// disabled.
+func tunnelIdleTimeout(seconds *int) time.Duration {
+	if seconds == nil {
+		return defaultTunnelIdleTimeout
+	}
+	return time.Duration(*seconds) * time.Second
+}
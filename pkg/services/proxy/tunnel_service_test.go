@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTunnelIdleTimeout(t *testing.T) {
+	seconds := func(value int) *int { return &value }
+
+	tests := []struct {
+		name    string
+		seconds *int
+		want    time.Duration
+	}{
+		{name: "default", want: defaultTunnelIdleTimeout},
+		{name: "disabled", seconds: seconds(0), want: 0},
+		{name: "configured", seconds: seconds(10), want: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tunnelIdleTimeout(tt.seconds); got != tt.want {
+				t.Errorf("tunnelIdleTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTunnelConnIdleDeadline(t *testing.T) {
+	tests := []struct {
+		name        string
+		idleTimeout time.Duration
+		wantClosed  bool
+	}{
+		{name: "idle tunnel is closed", idleTimeout: 50 * time.Millisecond, wantClosed: true},
+		{name: "disabled timeout keeps the tunnel open", idleTimeout: 0, wantClosed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			tunnel := &tunnelConn{Conn: server, idleTimeout: tt.idleTimeout}
+			defer tunnel.Close()
+
+			go func() {
+				time.Sleep(200 * time.Millisecond)
+				_, _ = client.Write([]byte("x"))
+			}()
+
+			_, err := tunnel.Read(make([]byte, 1))
+			if closed := err != nil; closed != tt.wantClosed {
+				t.Errorf("Read() error = %v, want closed %v", err, tt.wantClosed)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/letronghoangminh/reproxy/pkg/config"
+	"github.com/letronghoangminh/reproxy/pkg/interfaces"
+	serverpool "github.com/letronghoangminh/reproxy/pkg/services/proxy/server_pool"
+	"github.com/letronghoangminh/reproxy/pkg/utils"
+)
+
+const drainPollInterval = 500 * time.Millisecond
+
+var (
+	ErrUpstreamGroupNotFound = errors.New("handler has no upstreams")
+	ErrBackendNotFound       = errors.New("backend not found")
+	ErrBackendExists         = errors.New("backend already exists")
+)
+
+// GetServerPool returns the server pool serving a reverse proxy handler.
+func GetServerPool(handler *config.HandlerConfig) (interfaces.ServerPool, error) {
+	group := getUpstreamGroup(handler)
+	if group == nil {
+		return nil, ErrUpstreamGroupNotFound
+	}
+	return group.serverPool, nil
+}
+
+// GetTransportStats returns the connection counters of the transports of a handler.
+func GetTransportStats(handler *config.HandlerConfig) (TransportStats, error) {
+	group := getUpstreamGroup(handler)
+	if group == nil {
+		return TransportStats{}, ErrUpstreamGroupNotFound
+	}
+	return group.transportStats.snapshot(), nil
+}
+
+// AddUpstream adds a backend to the server pool of a handler at runtime. The change
+// lasts until the next configuration reload.
+func AddUpstream(handler *config.HandlerConfig, rawURL string, weight int) (interfaces.Backend, error) {
+	group := getUpstreamGroup(handler)
+	if group == nil {
+		return nil, ErrUpstreamGroupNotFound
+	}
+
+	endpoint, err := url.Parse(rawURL)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid upstream URL %q", rawURL)
+	}
+
+	if findBackend(group.serverPool, endpoint.String()) != nil {
+		return nil, ErrBackendExists
+	}
+
+	backendServer := newBackend(group, endpoint, weight)
+	group.serverPool.AddBackend(backendServer)
+
+	utils.Logger.Info("Backend added", "url", endpoint.String(), "weight", backendServer.GetWeight())
+	return backendServer, nil
+}
+
+// RemoveUpstream removes a backend from the server pool of a handler. Requests that
+// are already being proxied to it are not interrupted.
+func RemoveUpstream(handler *config.HandlerConfig, rawURL string) error {
+	group := getUpstreamGroup(handler)
+	if group == nil {
+		return ErrUpstreamGroupNotFound
+	}
+
+	backendServer := findBackend(group.serverPool, rawURL)
+	if backendServer == nil {
+		return ErrBackendNotFound
+	}
+
+	group.serverPool.RemoveBackend(backendServer)
+
+	utils.Logger.Info("Backend removed", "url", rawURL)
+	return nil
+}
+
+// SetUpstreamDisabled takes a backend out of rotation, or puts it back, without
+// removing it from the server pool.
+func SetUpstreamDisabled(handler *config.HandlerConfig, rawURL string, disabled bool) error {
+	group := getUpstreamGroup(handler)
+	if group == nil {
+		return ErrUpstreamGroupNotFound
+	}
+
+	backendServer := findBackend(group.serverPool, rawURL)
+	if backendServer == nil {
+		return ErrBackendNotFound
+	}
+
+	backendServer.SetDisabled(disabled)
+	if !disabled {
+		backendServer.SetDraining(false)
+	}
+
+	utils.Logger.Info("Backend state changed", "url", rawURL, "disabled", disabled)
+	return nil
+}
+
+// DrainUpstream stops sending new requests to a backend and removes it from the
+// server pool once its active connections have finished.
+func DrainUpstream(handler *config.HandlerConfig, rawURL string) error {
+	group := getUpstreamGroup(handler)
+	if group == nil {
+		return ErrUpstreamGroupNotFound
+	}
+
+	backendServer := findBackend(group.serverPool, rawURL)
+	if backendServer == nil {
+		return ErrBackendNotFound
+	}
+
+	if backendServer.IsDraining() {
+		return nil
+	}
+	backendServer.SetDraining(true)
+
+	utils.Logger.Info("Draining backend", "url", rawURL, "active_connections", backendServer.GetActiveConnections())
+
+	go func() {
+		t := time.NewTicker(drainPollInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				if !backendServer.IsDraining() {
+					return
+				}
+				if backendServer.GetActiveConnections() > 0 {
+					continue
+				}
+				group.serverPool.RemoveBackend(backendServer)
+				utils.Logger.Info("Backend drained and removed", "url", rawURL)
+				return
+			case <-group.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// RunHealthCheck checks every backend of a handler immediately instead of waiting for
+// the next scheduled health check.
+func RunHealthCheck(ctx context.Context, handler *config.HandlerConfig) error {
+	group := getUpstreamGroup(handler)
+	if group == nil {
+		return ErrUpstreamGroupNotFound
+	}
+
+	serverpool.HealthCheck(ctx, group.serverPool, group.healthChecker)
+	return nil
+}
+
+func findBackend(serverPool interfaces.ServerPool, rawURL string) interfaces.Backend {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+
+	for _, b := range serverPool.GetBackends() {
+		if b.GetURL().String() == target.String() {
+			return b
+		}
+	}
+	return nil
+}
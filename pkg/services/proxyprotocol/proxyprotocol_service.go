@@ -0,0 +1,189 @@
+// Package proxyprotocol provides a listener accepting the HAProxy PROXY protocol v1 and v2.
+package proxyprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// v2Signature starts every PROXY protocol v2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxV1Length is the longest v1 header, CRLF included.
+const maxV1Length = 107
+
+var errUntrustedPeer = errors.New("PROXY protocol header from an untrusted peer")
+
+type Listener struct {
+	net.Listener
+
+	// HeaderTimeout bounds the time to receive the header.
+	HeaderTimeout time.Duration
+	// Trusted reports whether a peer may send a header, nil trusts every peer.
+	Trusted func(ip net.IP) bool
+}
+
+// Accept returns connections whose RemoteAddr is the client address from the PROXY
+// protocol header. The header is read on the first Read or RemoteAddr call, so that a
+// slow peer does not block the accept loop. Connections with a missing or invalid
+// header, or from an untrusted peer, fail on their first Read.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn, reader: bufio.NewReader(conn), listener: l}, nil
+}
+
+type Conn struct {
+	net.Conn
+	reader     *bufio.Reader
+	listener   *Listener
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func (c *Conn) init() {
+	c.once.Do(func() {
+		c.remoteAddr = c.Conn.RemoteAddr()
+
+		if c.listener.Trusted != nil {
+			tcpAddr, ok := c.remoteAddr.(*net.TCPAddr)
+			if !ok || !c.listener.Trusted(tcpAddr.IP) {
+				c.err = errUntrustedPeer
+				return
+			}
+		}
+
+		if c.listener.HeaderTimeout > 0 {
+			_ = c.Conn.SetReadDeadline(time.Now().Add(c.listener.HeaderTimeout))
+			defer func() { _ = c.Conn.SetReadDeadline(time.Time{}) }()
+		}
+
+		var addr net.Addr
+		addr, c.err = readHeader(c.reader)
+		if addr != nil {
+			c.remoteAddr = addr
+		}
+	})
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address, or the peer address when the header is
+// invalid or has no address.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remoteAddr
+}
+
+// readHeader reads a v1 or v2 header and returns the source address it carries, which
+// is nil for LOCAL and UNKNOWN connections.
+func readHeader(reader *bufio.Reader) (net.Addr, error) {
+	// Every header is at least as long as the v2 signature.
+	signature, err := reader.Peek(len(v2Signature))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PROXY protocol header: %w", err)
+	}
+
+	if bytes.Equal(signature, v2Signature) {
+		return readV2(reader)
+	}
+	if bytes.HasPrefix(signature, []byte("PROXY ")) {
+		return readV1(reader)
+	}
+	return nil, errors.New("missing PROXY protocol header")
+}
+
+// readV1 parses "PROXY TCP4 <src> <dst> <sport> <dport>\r\n".
+func readV1(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < maxV1Length {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PROXY protocol header: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("invalid PROXY protocol v1 header")
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("invalid PROXY protocol v1 header")
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, errors.New("invalid PROXY protocol v1 address")
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readV2 parses the binary header: the signature, the version and command, the family,
+// the length of the addresses and TLVs, then the addresses.
+func readV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("failed to read PROXY protocol header: %w", err)
+	}
+
+	if header[12]>>4 != 2 {
+		return nil, errors.New("unsupported PROXY protocol version")
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, fmt.Errorf("failed to read PROXY protocol header: %w", err)
+	}
+
+	switch header[12] & 0x0f {
+	case 0x0:
+		// LOCAL: health checks of the proxy itself.
+		return nil, nil
+	case 0x1:
+		// PROXY: the addresses follow.
+	default:
+		return nil, errors.New("invalid PROXY protocol v2 command")
+	}
+
+	switch header[13] >> 4 {
+	case 0x1:
+		if len(payload) < 12 {
+			return nil, errors.New("invalid PROXY protocol v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x2:
+		if len(payload) < 36 {
+			return nil, errors.New("invalid PROXY protocol v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	}
+
+	// Unix sockets and unspecified families keep the peer address.
+	return nil, nil
+}
@@ -0,0 +1,202 @@
+package proxyprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// v2Header builds a v2 header from the version and command byte, the family and
+// protocol byte and the payload.
+func v2Header(versionCommand, family byte, payload []byte) []byte {
+	header := append([]byte{}, v2Signature...)
+	header = append(header, versionCommand, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	return append(header, payload...)
+}
+
+func ipv4Payload(src, dst string, srcPort, dstPort uint16) []byte {
+	payload := append([]byte{}, net.ParseIP(src).To4()...)
+	payload = append(payload, net.ParseIP(dst).To4()...)
+	payload = binary.BigEndian.AppendUint16(payload, srcPort)
+	return binary.BigEndian.AppendUint16(payload, dstPort)
+}
+
+func ipv6Payload(src, dst string, srcPort, dstPort uint16) []byte {
+	payload := append([]byte{}, net.ParseIP(src).To16()...)
+	payload = append(payload, net.ParseIP(dst).To16()...)
+	payload = binary.BigEndian.AppendUint16(payload, srcPort)
+	return binary.BigEndian.AppendUint16(payload, dstPort)
+}
+
+func TestReadHeader(t *testing.T) {
+	tlv := []byte{0x04, 0x00, 0x02, 'h', 'i'}
+
+	tests := []struct {
+		name    string
+		input   []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "v1 TCP4", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"), want: "192.0.2.1:56324"},
+		{name: "v1 TCP6", input: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"), want: "[2001:db8::1]:56324"},
+		{name: "v1 UNKNOWN", input: []byte("PROXY UNKNOWN\r\n")},
+		{name: "v1 UNKNOWN with addresses", input: []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n")},
+		{name: "v1 over-long line", input: []byte("PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n"), wantErr: true},
+		{name: "v1 without CR", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n"), wantErr: true},
+		{name: "v1 truncated", input: []byte("PROXY TCP4 192.0.2.1"), wantErr: true},
+		{name: "v1 unsupported protocol", input: []byte("PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n"), wantErr: true},
+		{name: "v1 missing field", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n"), wantErr: true},
+		{name: "v1 invalid address", input: []byte("PROXY TCP4 192.0.2.300 198.51.100.1 56324 443\r\n"), wantErr: true},
+		{name: "v1 invalid port", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 99999 443\r\n"), wantErr: true},
+		{name: "v2 LOCAL", input: v2Header(0x20, 0x00, nil)},
+		{name: "v2 LOCAL with addresses", input: v2Header(0x20, 0x11, ipv4Payload("192.0.2.1", "198.51.100.1", 1, 2))},
+		{name: "v2 PROXY IPv4", input: v2Header(0x21, 0x11, ipv4Payload("192.0.2.1", "198.51.100.1", 56324, 443)), want: "192.0.2.1:56324"},
+		{name: "v2 PROXY IPv4 with TLVs", input: v2Header(0x21, 0x11, append(ipv4Payload("192.0.2.1", "198.51.100.1", 56324, 443), tlv...)), want: "192.0.2.1:56324"},
+		{name: "v2 PROXY IPv6", input: v2Header(0x21, 0x21, ipv6Payload("2001:db8::1", "2001:db8::2", 56324, 443)), want: "[2001:db8::1]:56324"},
+		{name: "v2 PROXY unix", input: v2Header(0x21, 0x31, make([]byte, 216))},
+		{name: "v2 PROXY unspecified family", input: v2Header(0x21, 0x00, nil)},
+		{name: "v2 IPv4 payload too short", input: v2Header(0x21, 0x11, make([]byte, 8)), wantErr: true},
+		{name: "v2 IPv6 payload too short", input: v2Header(0x21, 0x21, make([]byte, 12)), wantErr: true},
+		{name: "v2 truncated payload", input: v2Header(0x21, 0x11, ipv4Payload("192.0.2.1", "198.51.100.1", 1, 2))[:22], wantErr: true},
+		{name: "v2 truncated header", input: v2Header(0x21, 0x11, nil)[:14], wantErr: true},
+		{name: "v2 unsupported version", input: v2Header(0x11, 0x11, ipv4Payload("192.0.2.1", "198.51.100.1", 1, 2)), wantErr: true},
+		{name: "v2 invalid command", input: v2Header(0x22, 0x11, ipv4Payload("192.0.2.1", "198.51.100.1", 1, 2)), wantErr: true},
+		{name: "missing header", input: []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"), wantErr: true},
+		{name: "short input", input: []byte("PROXY"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := readHeader(bufio.NewReader(bytes.NewReader(tt.input)))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got address %v", addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readHeader: %v", err)
+			}
+
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != tt.want {
+				t.Errorf("address = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadHeaderLeavesPayload(t *testing.T) {
+	input := append(v2Header(0x21, 0x11, ipv4Payload("192.0.2.1", "198.51.100.1", 56324, 443)), "GET / HTTP/1.1\r\n"...)
+	reader := bufio.NewReader(bytes.NewReader(input))
+
+	if _, err := readHeader(reader); err != nil {
+		t.Fatal(err)
+	}
+	rest, _ := io.ReadAll(reader)
+	if string(rest) != "GET / HTTP/1.1\r\n" {
+		t.Errorf("payload after the header = %q", rest)
+	}
+}
+
+// dial connects to a PROXY protocol listener, writes data and returns the accepted
+// connection.
+func dial(t *testing.T, listener *Listener, data []byte) net.Conn {
+	t.Helper()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	if len(data) > 0 {
+		if _, err := client.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func newListener(t *testing.T, trusted func(net.IP) bool) *Listener {
+	t.Helper()
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = inner.Close() })
+	return &Listener{Listener: inner, HeaderTimeout: time.Second, Trusted: trusted}
+}
+
+func TestListenerTrustedPeer(t *testing.T) {
+	listener := newListener(t, func(ip net.IP) bool { return ip.IsLoopback() })
+	conn := dial(t, listener, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nhello"))
+
+	if got := conn.RemoteAddr().String(); got != "192.0.2.1:56324" {
+		t.Errorf("RemoteAddr() = %s, want 192.0.2.1:56324", got)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("Read() = %q, %v", buf, err)
+	}
+}
+
+func TestListenerLocalKeepsPeerAddress(t *testing.T) {
+	listener := newListener(t, nil)
+	conn := dial(t, listener, v2Header(0x20, 0x00, nil))
+
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); !ok || !addr.IP.IsLoopback() {
+		t.Errorf("RemoteAddr() = %v, want the loopback peer", conn.RemoteAddr())
+	}
+}
+
+func TestListenerUntrustedPeer(t *testing.T) {
+	listener := newListener(t, func(net.IP) bool { return false })
+	conn := dial(t, listener, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"))
+
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, errUntrustedPeer) {
+		t.Errorf("Read() error = %v, want %v", err, errUntrustedPeer)
+	}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); !ok || !addr.IP.IsLoopback() {
+		t.Errorf("RemoteAddr() = %v, want the untrusted peer", conn.RemoteAddr())
+	}
+}
+
+func TestListenerMissingHeader(t *testing.T) {
+	listener := newListener(t, nil)
+	conn := dial(t, listener, []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("expected a connection without header to fail")
+	}
+}
+
+func TestListenerHeaderTimeout(t *testing.T) {
+	listener := newListener(t, nil)
+	listener.HeaderTimeout = 50 * time.Millisecond
+	conn := dial(t, listener, nil)
+
+	start := time.Now()
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("expected a silent peer to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("header timeout took %v", elapsed)
+	}
+}
@@ -33,7 +33,7 @@ func (h *StaticFileHandler) ServeFile(w http.ResponseWriter, r *http.Request, cf
 		return errors.New("static file root is not configured")
 	}
 
-	requestPath := strings.TrimPrefix(r.URL.Path, cfg.Matchers.Path)
+	requestPath := strings.TrimPrefix(r.URL.Path, utils.GetRequestInfo(r.Context()).PathPrefix())
 	cleanPath, err := h.sanitizePath(requestPath)
 	if err != nil {
 		h.logger.Error("Invalid file path", "path", requestPath, "error", err)
@@ -104,7 +104,7 @@ func (h *StaticFileHandler) ServeStaticResponse(w http.ResponseWriter, r *http.R
 
 	w.WriteHeader(statusCode)
 	addPoweredByHeader(w)
-	_, err := w.Write([]byte(cfg.StaticResponse.Body))
+	_, err := w.Write([]byte(utils.ReplacePlaceholders(r, cfg.StaticResponse.Body)))
 	if err != nil {
 		h.logger.Error("Error writing response", "error", err)
 		return err
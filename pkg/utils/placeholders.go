@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RequestPlaceholders returns the values of the request placeholders that can be used
+// in header values and access log fields, keyed by placeholder (e.g. "{host}"). The
+// named captures of the matched path pattern are included, without overriding the
+// built-in placeholders.
+func RequestPlaceholders(r *http.Request) map[string]string {
+	placeholders := map[string]string{
+		"{remote_ip}":  RemoteIP(r),
+		"{scheme}":     requestScheme(r),
+		"{host}":       r.Host,
+		"{path}":       r.URL.Path,
+		"{query}":      r.URL.RawQuery,
+		"{method}":     r.Method,
+		"{user_agent}": r.UserAgent(),
+	}
+
+	for name, value := range GetRequestInfo(r.Context()).Captures() {
+		if _, ok := placeholders["{"+name+"}"]; !ok {
+			placeholders["{"+name+"}"] = value
+		}
+	}
+
+	return placeholders
+}
+
+// ReplacePlaceholders replaces the request placeholders found in value.
+func ReplacePlaceholders(r *http.Request, value string) string {
+	if !strings.Contains(value, "{") {
+		return value
+	}
+	return PlaceholderReplacer(RequestPlaceholders(r)).Replace(value)
+}
+
+// PlaceholderReplacer returns a replacer of placeholders by their values. Values are
+// substituted in a single pass, a value containing a placeholder is kept as is.
+func PlaceholderReplacer(placeholders map[string]string) *strings.Replacer {
+	oldnew := make([]string, 0, 2*len(placeholders))
+	for placeholder, replacement := range placeholders {
+		oldnew = append(oldnew, placeholder, replacement)
+	}
+	return strings.NewReplacer(oldnew...)
+}
+
+// RemoteIP returns the address of the client without its port, as found behind
+// trusted proxies when the request went through the listener.
+func RemoteIP(r *http.Request) string {
+	if clientIP := GetRequestInfo(r.Context()).ClientIP(); clientIP != "" {
+		return clientIP
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func requestScheme(r *http.Request) string {
+	if r.URL.Scheme != "" {
+		return r.URL.Scheme
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReplacePlaceholders(t *testing.T) {
+	ctx, info := WithRequestInfo(context.Background())
+	info.SetClientIP("203.0.113.5")
+	info.SetPathMatch("/files", map[string]string{"name": "{host}", "host": "ignored"})
+
+	r := httptest.NewRequestWithContext(ctx, "GET", "/files/{path}?q=1", nil)
+	r.Host = "example.com"
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "no placeholders", want: "no placeholders"},
+		{value: "{remote_ip} {host} {query} {method}", want: "203.0.113.5 example.com q=1 GET"},
+		{value: "{unknown}", want: "{unknown}"},
+		// Values containing placeholders are not replaced again.
+		{value: "{name}", want: "{host}"},
+		{value: "{path}", want: "/files/{path}"},
+		{value: "{{host}}", want: "{example.com}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := ReplacePlaceholders(r, tt.value); got != tt.want {
+				t.Errorf("ReplacePlaceholders(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"context"
+	"sync"
+)
+
+type requestInfoKey struct{}
+
+// RequestInfo collects details discovered while a request is being handled, such as
+// the upstream it was proxied to, so they can be reported once the response is done.
+type RequestInfo struct {
+	mutex      sync.RWMutex
+	upstream   string
+	pathPrefix string
+	captures   map[string]string
+	clientIP   string
+}
+
+func (i *RequestInfo) SetUpstream(upstream string) {
+	if i == nil {
+		return
+	}
+	i.mutex.Lock()
+	i.upstream = upstream
+	i.mutex.Unlock()
+}
+
+func (i *RequestInfo) Upstream() string {
+	if i == nil {
+		return ""
+	}
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return i.upstream
+}
+
+// SetClientIP records the client address found behind trusted proxies.
+func (i *RequestInfo) SetClientIP(clientIP string) {
+	if i == nil {
+		return
+	}
+	i.mutex.Lock()
+	i.clientIP = clientIP
+	i.mutex.Unlock()
+}
+
+func (i *RequestInfo) ClientIP() string {
+	if i == nil {
+		return ""
+	}
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return i.clientIP
+}
+
+// SetPathMatch records the path prefix matched by the handler, which is stripped
+// before proxying or serving files, and the named captures of its path pattern.
+func (i *RequestInfo) SetPathMatch(prefix string, captures map[string]string) {
+	if i == nil {
+		return
+	}
+	i.mutex.Lock()
+	i.pathPrefix = prefix
+	i.captures = captures
+	i.mutex.Unlock()
+}
+
+func (i *RequestInfo) PathPrefix() string {
+	if i == nil {
+		return ""
+	}
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return i.pathPrefix
+}
+
+func (i *RequestInfo) Captures() map[string]string {
+	if i == nil {
+		return nil
+	}
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return i.captures
+}
+
+func WithRequestInfo(ctx context.Context) (context.Context, *RequestInfo) {
+	info := &RequestInfo{}
+	return context.WithValue(ctx, requestInfoKey{}, info), info
+}
+
+// GetRequestInfo returns the request info stored in ctx. The returned value may be
+// nil, which all RequestInfo methods accept.
+func GetRequestInfo(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return info
+}
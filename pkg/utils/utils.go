@@ -5,6 +5,8 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"hash/fnv"
+	"net/http"
+	"strings"
 	"sync/atomic"
 	"time"
 )
@@ -51,6 +53,14 @@ func GenerateRequestID() string {
 		})
 }
 
+// OrDefault returns fallback when an optional setting is left at 0.
+func OrDefault(value, fallback int) int {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
+
 // IsPathSafe checks if the given path is safe (doesn't contain traversal attempts)
 func IsPathSafe(path string) bool {
 	return path != ".." &&
@@ -64,3 +74,19 @@ func IsPathSafe(path string) bool {
 		path != ">" &&
 		path != "<"
 }
+
+// IsUpgradeRequest reports whether the request asks to switch protocols, as
+// WebSocket handshakes do.
+func IsUpgradeRequest(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}